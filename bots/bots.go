@@ -2,23 +2,29 @@ package bots
 
 import (
 	"context"
+	"time"
 
 	"limitless/engine"
 )
 
-// Bot represents a trading agent that can be run under a supervisor.
+// Bot represents a trading agent that can be run under a supervisor. Start
+// drives the bot on real-time tickers; Step runs a single tick, so a
+// simulation can drive it from a stepped clock instead.
 type Bot interface {
 	Start(ctx context.Context, client EngineClient)
+	Step(ctx context.Context, client EngineClient)
 }
 
 // EngineClient abstracts the minimal surface bots need from the matching engine.
 type EngineClient interface {
 	SubmitOrder(ctx context.Context, order engine.Order) error
 	CancelOrder(ctx context.Context, orderID string) error
+	OrderStatus(ctx context.Context, orderID string) (engine.OrderStatus, error)
 	Snapshot(ctx context.Context) (engine.BookView, error)
 	Trades() <-chan engine.MatchResult
 	Symbol() string
 	TickSize() int64
 	NextID(prefix string) string
 	OwnsOrder(id string) bool
+	Now() time.Time
 }
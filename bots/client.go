@@ -9,27 +9,39 @@ import (
 	"limitless/engine"
 )
 
+// botOwner is the account every bot order is placed under, so the bots never
+// trade with one another.
+const botOwner = "bots"
+
 type ThrottledClient struct {
 	book     *engine.OrderBook
 	symbol   string
 	tickSize int64
 	throttle <-chan time.Time
-	trades   <-chan engine.MatchResult
+	trades   *engine.Subscription[engine.MatchResult]
 	mu       sync.Mutex
 	orderSeq int64
-	owned    map[string]struct{}
+	owner    string
 }
 
 // NewThrottledClient wraps an order book with basic rate limiting and bookkeeping.
+// It subscribes to the book's trades itself, so it never competes with other
+// consumers of the book; OwnsOrder tells its own fills apart.
 func NewThrottledClient(book *engine.OrderBook, symbol string, tickSize int64, throttle <-chan time.Time) *ThrottledClient {
-	return &ThrottledClient{
+	c := &ThrottledClient{
 		book:     book,
 		symbol:   symbol,
 		tickSize: tickSize,
 		throttle: throttle,
-		trades:   book.Trades(),
-		owned:    make(map[string]struct{}),
+		owner:    botOwner,
 	}
+	c.trades = book.SubscribeTrades(engine.SubscribeOptions{Policy: engine.OutputSpill})
+	return c
+}
+
+// Close stops the client's trade subscription.
+func (c *ThrottledClient) Close() {
+	c.trades.Unsubscribe()
 }
 
 func (c *ThrottledClient) waitThrottle(ctx context.Context) error {
@@ -51,16 +63,18 @@ func (c *ThrottledClient) SubmitOrder(ctx context.Context, order engine.Order) e
 	if order.Symbol == "" {
 		order.Symbol = c.symbol
 	}
+	if order.Owner == "" && c.owner != "" {
+		order.Owner = c.owner
+		order.SelfTrade = engine.SelfTradeCancelNewest
+	}
 	if order.Price > 0 && order.Price%c.tickSize != 0 {
 		order.Price = (order.Price / c.tickSize) * c.tickSize
+		// Rounding a post-only ask down could make it cross; round it away from the book instead.
+		if order.PostOnly != engine.PostOnlyOff && order.Side == engine.Sell {
+			order.Price += c.tickSize
+		}
 	}
-	if err := c.book.SubmitOrder(order); err != nil {
-		return err
-	}
-	c.mu.Lock()
-	c.owned[order.ID] = struct{}{}
-	c.mu.Unlock()
-	return nil
+	return c.book.SubmitOrder(order)
 }
 
 func (c *ThrottledClient) CancelOrder(ctx context.Context, orderID string) error {
@@ -72,6 +86,14 @@ func (c *ThrottledClient) CancelOrder(ctx context.Context, orderID string) error
 	return c.book.CancelOrder(orderID)
 }
 
+// OrderStatus asks the book where an order stands.
+func (c *ThrottledClient) OrderStatus(ctx context.Context, orderID string) (engine.OrderStatus, error) {
+	if err := ctx.Err(); err != nil {
+		return engine.OrderStatus{}, err
+	}
+	return c.book.OrderStatus(orderID)
+}
+
 func (c *ThrottledClient) Snapshot(ctx context.Context) (engine.BookView, error) {
 	type result struct {
 		view engine.BookView
@@ -92,7 +114,7 @@ func (c *ThrottledClient) Snapshot(ctx context.Context) (engine.BookView, error)
 }
 
 func (c *ThrottledClient) Trades() <-chan engine.MatchResult {
-	return c.trades
+	return c.trades.C
 }
 
 func (c *ThrottledClient) Symbol() string {
@@ -103,6 +125,11 @@ func (c *ThrottledClient) TickSize() int64 {
 	return c.tickSize
 }
 
+// Now reads the book's clock, so bots follow simulated time when the book does.
+func (c *ThrottledClient) Now() time.Time {
+	return c.book.Clock().Now()
+}
+
 func (c *ThrottledClient) NextID(prefix string) string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -110,9 +137,10 @@ func (c *ThrottledClient) NextID(prefix string) string {
 	return fmt.Sprintf("%s-%d", prefix, c.orderSeq)
 }
 
+// OwnsOrder reports whether the book has the order under the client's owner.
+// The book remembers finished orders for a while, so fills can be matched to
+// their orders after the fact.
 func (c *ThrottledClient) OwnsOrder(id string) bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, ok := c.owned[id]
-	return ok
+	status, err := c.book.OrderStatus(id)
+	return err == nil && status.Order.Owner == c.owner
 }
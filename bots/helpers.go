@@ -1,6 +1,11 @@
 package bots
 
-import "limitless/engine"
+import (
+	"context"
+	"time"
+
+	"limitless/engine"
+)
 
 func midPrice(view engine.BookView) int64 {
 	bid := int64(0)
@@ -23,3 +28,28 @@ func midPrice(view engine.BookView) int64 {
 		return 0
 	}
 }
+
+// liveOrder is a resting order a bot placed and will cancel once it ages out.
+type liveOrder struct {
+	id       string
+	placedAt time.Time
+}
+
+// cancelExpired cancels every order placed more than lifetime ago by the
+// client's clock and returns the ones still live. Orders the book has
+// already finished are dropped without a cancel.
+func cancelExpired(ctx context.Context, client EngineClient, live []liveOrder, lifetime time.Duration) []liveOrder {
+	now := client.Now()
+	kept := live[:0]
+	for _, order := range live {
+		if status, err := client.OrderStatus(ctx, order.id); err != nil || status.LeavesQty == 0 {
+			continue
+		}
+		if now.Sub(order.placedAt) >= lifetime {
+			_ = client.CancelOrder(ctx, order.id)
+			continue
+		}
+		kept = append(kept, order)
+	}
+	return kept
+}
@@ -15,6 +15,7 @@ type RandomAskBot struct {
 	Quantity   int64
 	RangeTicks int64
 	rand       *rand.Rand
+	live       []liveOrder
 }
 
 func NewRandomAskBot() *RandomAskBot {
@@ -27,6 +28,11 @@ func NewRandomAskBot() *RandomAskBot {
 	}
 }
 
+// Seed makes the bot's price choices repeatable.
+func (b *RandomAskBot) Seed(seed int64) {
+	b.rand = rand.New(rand.NewSource(seed))
+}
+
 func (b *RandomAskBot) Start(ctx context.Context, client EngineClient) {
 	ticker := time.NewTicker(b.Interval)
 	defer ticker.Stop()
@@ -36,11 +42,17 @@ func (b *RandomAskBot) Start(ctx context.Context, client EngineClient) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			b.placeAsk(ctx, client)
+			b.Step(ctx, client)
 		}
 	}
 }
 
+// Step cancels asks that have outlived Lifetime and places a new one.
+func (b *RandomAskBot) Step(ctx context.Context, client EngineClient) {
+	b.live = cancelExpired(ctx, client, b.live, b.Lifetime)
+	b.placeAsk(ctx, client)
+}
+
 func (b *RandomAskBot) placeAsk(ctx context.Context, client EngineClient) {
 	view, err := client.Snapshot(ctx)
 	if err != nil {
@@ -60,16 +72,5 @@ func (b *RandomAskBot) placeAsk(ctx context.Context, client EngineClient) {
 		return
 	}
 
-	go b.cancelAfter(ctx, client, id)
-}
-
-func (b *RandomAskBot) cancelAfter(ctx context.Context, client EngineClient, orderID string) {
-	timer := time.NewTimer(b.Lifetime)
-	defer timer.Stop()
-	select {
-	case <-ctx.Done():
-		return
-	case <-timer.C:
-		_ = client.CancelOrder(context.Background(), orderID)
-	}
+	b.live = append(b.live, liveOrder{id: id, placedAt: client.Now()})
 }
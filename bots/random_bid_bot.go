@@ -15,6 +15,7 @@ type RandomBidBot struct {
 	Quantity   int64
 	RangeTicks int64
 	rand       *rand.Rand
+	live       []liveOrder
 }
 
 func NewRandomBidBot() *RandomBidBot {
@@ -27,6 +28,11 @@ func NewRandomBidBot() *RandomBidBot {
 	}
 }
 
+// Seed makes the bot's price choices repeatable.
+func (b *RandomBidBot) Seed(seed int64) {
+	b.rand = rand.New(rand.NewSource(seed))
+}
+
 func (b *RandomBidBot) Start(ctx context.Context, client EngineClient) {
 	ticker := time.NewTicker(b.Interval)
 	defer ticker.Stop()
@@ -36,11 +42,17 @@ func (b *RandomBidBot) Start(ctx context.Context, client EngineClient) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			b.placeBid(ctx, client)
+			b.Step(ctx, client)
 		}
 	}
 }
 
+// Step cancels bids that have outlived Lifetime and places a new one.
+func (b *RandomBidBot) Step(ctx context.Context, client EngineClient) {
+	b.live = cancelExpired(ctx, client, b.live, b.Lifetime)
+	b.placeBid(ctx, client)
+}
+
 func (b *RandomBidBot) placeBid(ctx context.Context, client EngineClient) {
 	view, err := client.Snapshot(ctx)
 	if err != nil {
@@ -63,16 +75,5 @@ func (b *RandomBidBot) placeBid(ctx context.Context, client EngineClient) {
 		return
 	}
 
-	go b.cancelAfter(ctx, client, id)
-}
-
-func (b *RandomBidBot) cancelAfter(ctx context.Context, client EngineClient, orderID string) {
-	timer := time.NewTimer(b.Lifetime)
-	defer timer.Stop()
-	select {
-	case <-ctx.Done():
-		return
-	case <-timer.C:
-		_ = client.CancelOrder(context.Background(), orderID)
-	}
+	b.live = append(b.live, liveOrder{id: id, placedAt: client.Now()})
 }
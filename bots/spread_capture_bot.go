@@ -13,6 +13,9 @@ type SpreadCaptureBot struct {
 	Lifetime       time.Duration
 	ThresholdTicks int64
 	Quantity       int64
+	// PostOnly keeps quotes passive when the book moves between Snapshot and SubmitOrder.
+	PostOnly engine.PostOnlyMode
+	pair     *pairedOrders
 }
 
 type pairedOrders struct {
@@ -28,6 +31,7 @@ func NewSpreadCaptureBot() *SpreadCaptureBot {
 		Lifetime:       3 * time.Second,
 		ThresholdTicks: 3,
 		Quantity:       1,
+		PostOnly:       engine.PostOnlySlide,
 	}
 }
 
@@ -35,21 +39,25 @@ func (b *SpreadCaptureBot) Start(ctx context.Context, client EngineClient) {
 	ticker := time.NewTicker(b.Interval)
 	defer ticker.Stop()
 
-	var pair *pairedOrders
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			view, err := client.Snapshot(ctx)
-			if err != nil {
-				continue
-			}
-			pair = b.refreshPair(ctx, client, view, pair)
+			b.Step(ctx, client)
 		}
 	}
 }
 
+// Step re-prices or refreshes the quote pair against the current book.
+func (b *SpreadCaptureBot) Step(ctx context.Context, client EngineClient) {
+	view, err := client.Snapshot(ctx)
+	if err != nil {
+		return
+	}
+	b.pair = b.refreshPair(ctx, client, view, b.pair)
+}
+
 func (b *SpreadCaptureBot) refreshPair(ctx context.Context, client EngineClient, view engine.BookView, pair *pairedOrders) *pairedOrders {
 	bid := view.BestBid
 	ask := view.BestAsk
@@ -60,7 +68,7 @@ func (b *SpreadCaptureBot) refreshPair(ctx context.Context, client EngineClient,
 	threshold := b.ThresholdTicks * client.TickSize()
 
 	if pair != nil {
-		if time.Since(pair.placedAt) > b.Lifetime {
+		if client.Now().Sub(pair.placedAt) > b.Lifetime {
 			return b.cancelPair(ctx, client, pair)
 		}
 		if absInt64(mid-pair.anchorMid) >= threshold {
@@ -84,8 +92,8 @@ func (b *SpreadCaptureBot) refreshPair(ctx context.Context, client EngineClient,
 	buyID := client.NextID("spread-bid")
 	sellID := client.NextID("spread-ask")
 
-	buyOrder := engine.Order{ID: buyID, Symbol: client.Symbol(), Side: engine.Buy, Type: engine.Limit, Price: buyPrice, Quantity: b.Quantity}
-	sellOrder := engine.Order{ID: sellID, Symbol: client.Symbol(), Side: engine.Sell, Type: engine.Limit, Price: sellPrice, Quantity: b.Quantity}
+	buyOrder := engine.Order{ID: buyID, Symbol: client.Symbol(), Side: engine.Buy, Type: engine.Limit, Price: buyPrice, Quantity: b.Quantity, PostOnly: b.PostOnly}
+	sellOrder := engine.Order{ID: sellID, Symbol: client.Symbol(), Side: engine.Sell, Type: engine.Limit, Price: sellPrice, Quantity: b.Quantity, PostOnly: b.PostOnly}
 
 	if err := client.SubmitOrder(ctx, buyOrder); err != nil {
 		return pair
@@ -95,7 +103,7 @@ func (b *SpreadCaptureBot) refreshPair(ctx context.Context, client EngineClient,
 		return pair
 	}
 
-	return &pairedOrders{buyID: buyID, sellID: sellID, anchorMid: mid, placedAt: time.Now()}
+	return &pairedOrders{buyID: buyID, sellID: sellID, anchorMid: mid, placedAt: client.Now()}
 }
 
 func (b *SpreadCaptureBot) cancelPair(ctx context.Context, client EngineClient, pair *pairedOrders) *pairedOrders {
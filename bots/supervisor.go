@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -22,26 +23,32 @@ type Supervisor struct {
 func NewSupervisor(book *engine.OrderBook, cfg engine.OrderBookConfig, orderInterval time.Duration) *Supervisor {
 	throttle := time.NewTicker(orderInterval)
 	client := NewThrottledClient(book, cfg.Symbol, cfg.TickSize, throttle.C)
-	bots := []Bot{
+	return &Supervisor{
+		bots:     defaultBots(),
+		client:   client,
+		pnl:      &pnlTracker{},
+		throttle: throttle,
+	}
+}
+
+func defaultBots() []Bot {
+	return []Bot{
 		NewRandomBidBot(),
 		NewRandomAskBot(),
 		NewRandomBidBot(),
 		NewRandomAskBot(),
 		NewSpreadCaptureBot(),
 	}
-	return &Supervisor{
-		bots:     bots,
-		client:   client,
-		pnl:      &pnlTracker{},
-		throttle: throttle,
-	}
 }
 
 // Start launches all bots and PnL monitoring until the context is canceled.
 func (s *Supervisor) Start(ctx context.Context) {
 	logTicker := time.NewTicker(2 * time.Second)
 	defer logTicker.Stop()
-	defer s.throttle.Stop()
+	if s.throttle != nil {
+		defer s.throttle.Stop()
+	}
+	defer s.client.Close()
 
 	for _, bot := range s.bots {
 		b := bot
@@ -56,7 +63,31 @@ func (s *Supervisor) Start(ctx context.Context) {
 			return
 		case <-logTicker.C:
 			pos, cash := s.pnl.Snapshot()
-			log.Printf("PNL position=%d cash=%d", pos, cash)
+			made, taken := s.pnl.Liquidity()
+			log.Printf("PNL position=%d cash=%d made=%d taken=%d", pos, cash, made, taken)
+		}
+	}
+}
+
+// Step runs every bot once, in order, and books the resulting fills. Stepping
+// replaces Start when the session is driven from a simulated clock.
+func (s *Supervisor) Step(ctx context.Context) {
+	for _, bot := range s.bots {
+		bot.Step(ctx, s.client)
+		s.drainTrades()
+	}
+}
+
+func (s *Supervisor) drainTrades() {
+	for {
+		select {
+		case trade, ok := <-s.client.Trades():
+			if !ok {
+				return
+			}
+			s.pnl.Record(trade, s.client)
+		default:
+			return
 		}
 	}
 }
@@ -79,6 +110,8 @@ type pnlTracker struct {
 	mu       sync.Mutex
 	position int64
 	cash     int64
+	made     int64 // quantity filled while resting on the book
+	taken    int64 // quantity filled by crossing the book
 }
 
 func (p *pnlTracker) Record(trade engine.MatchResult, client EngineClient) {
@@ -92,6 +125,12 @@ func (p *pnlTracker) Record(trade engine.MatchResult, client EngineClient) {
 		p.position -= trade.Quantity
 		p.cash += trade.Price * trade.Quantity
 	}
+	if client.OwnsOrder(trade.MakerOrderID) {
+		p.made += trade.Quantity
+	}
+	if client.OwnsOrder(trade.TakerOrderID) {
+		p.taken += trade.Quantity
+	}
 }
 
 func (p *pnlTracker) Snapshot() (int64, int64) {
@@ -100,6 +139,14 @@ func (p *pnlTracker) Snapshot() (int64, int64) {
 	return p.position, p.cash
 }
 
+// Liquidity returns the quantity the bots provided as makers and took as
+// takers.
+func (p *pnlTracker) Liquidity() (made, taken int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.made, p.taken
+}
+
 // RunExampleSupervisor demonstrates spinning up the supervisor with a fresh book.
 func RunExampleSupervisor() {
 	cfg := engine.OrderBookConfig{Symbol: "SIM", TickSize: 1, MaxDepth: 50}
@@ -111,3 +158,41 @@ func RunExampleSupervisor() {
 	book.Stop()
 	fmt.Printf("final PNL position=%d cash=%d\n", sup.pnl.position, sup.pnl.cash)
 }
+
+// RunSimulation plays the default bot swarm for steps ticks of stepSize on an
+// inline book with a simulated clock starting at start. The same seed always
+// yields the same session, and it runs as fast as the engine allows.
+func RunSimulation(cfg engine.OrderBookConfig, start time.Time, steps int, stepSize time.Duration, seed int64) (position, cash int64) {
+	clock := engine.NewSimClock(start)
+	cfg.Clock = clock
+	cfg.Inline = true
+	book := engine.NewOrderBook(cfg)
+	defer book.Stop()
+
+	rng := rand.New(rand.NewSource(seed))
+	sup := &Supervisor{
+		bots:   defaultBots(),
+		client: NewThrottledClient(book, cfg.Symbol, cfg.TickSize, nil),
+		pnl:    &pnlTracker{},
+	}
+	defer sup.client.Close()
+	// The swarm is the whole market here, so the bots must trade each other.
+	sup.client.owner = ""
+	for _, bot := range sup.bots {
+		if seeded, ok := bot.(interface{ Seed(int64) }); ok {
+			seeded.Seed(rng.Int63())
+		}
+	}
+
+	// Seed the book around a mid so the random bots have a price to quote.
+	mid := 100 * cfg.TickSize
+	ctx := context.Background()
+	_ = sup.client.SubmitOrder(ctx, engine.Order{ID: sup.client.NextID("seed-bid"), Side: engine.Buy, Type: engine.Limit, Price: mid - cfg.TickSize, Quantity: 1})
+	_ = sup.client.SubmitOrder(ctx, engine.Order{ID: sup.client.NextID("seed-ask"), Side: engine.Sell, Type: engine.Limit, Price: mid + cfg.TickSize, Quantity: 1})
+
+	for i := 0; i < steps; i++ {
+		sup.Step(ctx)
+		clock.Advance(stepSize)
+	}
+	return sup.pnl.Snapshot()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"limitless/engine"
+)
+
+// event is one line of replay output. Each journaled command produces its
+// result, the trades it caused, and the resulting book.
+type event struct {
+	Index   int64               `json:"index"`
+	Op      string              `json:"op,omitempty"`
+	OrderID string              `json:"orderId,omitempty"`
+	Error   string              `json:"error,omitempty"`
+	Trade   *engine.MatchResult `json:"trade,omitempty"`
+	Book    *engine.Depth       `json:"book,omitempty"`
+}
+
+// options is the book configuration a replay runs with; it must match the
+// production book's.
+type options struct {
+	symbol      string
+	tick        int64
+	maxDepth    int
+	levels      int
+	fees        *engine.FeeSchedule
+	dedupWindow time.Duration
+	history     int
+}
+
+func main() {
+	journalPath := flag.String("journal", "", "journal file to replay (sealed segments beside it are included)")
+	symbol := flag.String("symbol", "", "book symbol (defaults to the first order's symbol)")
+	tick := flag.Int64("tick", 1, "tick size the session ran with")
+	maxDepth := flag.Int("max-depth", 100, "max depth the session ran with")
+	feesPath := flag.String("fees", "", "fee schedule the session ran with (the server's FEES_FILE)")
+	dedupWindow := flag.Duration("dedup-window", time.Hour, "how long finished order IDs stayed taken (the server's DEDUP_WINDOW)")
+	history := flag.Int("history", 0, "finished orders the book remembered (0 for the engine default)")
+	levels := flag.Int("levels", 10, "book levels per side to emit after each command (0 for all)")
+	outPath := flag.String("out", "", "write output to file instead of stdout")
+	diffPath := flag.String("diff", "", "compare output against a previously recorded replay")
+	flag.Parse()
+
+	if *journalPath == "" {
+		fmt.Fprintln(os.Stderr, "-journal is required")
+		os.Exit(2)
+	}
+	opts := options{symbol: *symbol, tick: *tick, maxDepth: *maxDepth, levels: *levels, dedupWindow: *dedupWindow, history: *history}
+	if *feesPath != "" {
+		fees, err := engine.ReadFeeSchedule(*feesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.fees = fees
+	}
+	records, err := engine.ReadJournal(*journalPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read journal: %v\n", err)
+		os.Exit(1)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create output: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	var diff *differ
+	if *diffPath != "" {
+		f, err := os.Open(*diffPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open recorded run: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		diff = newDiffer(f)
+	}
+
+	err = replay(records, opts, w, diff)
+	w.Flush()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if diff != nil {
+		fmt.Fprintf(os.Stderr, "replay of %d records matches %s\n", len(records), *diffPath)
+	}
+}
+
+// replay feeds records into a fresh book and writes the outcome of each to w.
+// With a differ it stops at the first line that differs from the recorded
+// run.
+func replay(records []engine.JournalRecord, opts options, w io.Writer, diff *differ) error {
+	if len(records) > 0 && records[0].Index != 1 {
+		return fmt.Errorf("journal starts at record %d; earlier history was truncated by a snapshot", records[0].Index)
+	}
+	if opts.symbol == "" {
+		opts.symbol = firstSymbol(records)
+	}
+
+	clock := engine.NewSimClock(time.Time{})
+	book := engine.NewOrderBook(engine.OrderBookConfig{
+		Symbol:      opts.symbol,
+		TickSize:    opts.tick,
+		MaxDepth:    opts.maxDepth,
+		Inline:      true,
+		Clock:       clock,
+		Fees:        opts.fees,
+		History:     opts.history,
+		DedupWindow: opts.dedupWindow,
+	})
+	defer book.Stop()
+
+	emit := func(ev event) error {
+		line, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("encode output: %w", err)
+		}
+		w.Write(append(line, '\n'))
+		if diff != nil {
+			if msg := diff.check(line); msg != "" {
+				return errors.New(msg)
+			}
+		}
+		return nil
+	}
+
+	// A record can cause any number of trades, so they are read while it is
+	// applied. The subscription blocks rather than drops, so once the record
+	// returns every trade it caused is already in the channel.
+	trades := book.SubscribeTrades(engine.SubscribeOptions{Policy: engine.OutputBlock}).C
+	for _, rec := range records {
+		clock.Set(rec.Timestamp)
+		ev := event{Index: rec.Index}
+		switch rec.Op {
+		case engine.JournalAdd:
+			ev.Op, ev.OrderID = "add", rec.Order.ID
+		case engine.JournalCancel:
+			ev.Op, ev.OrderID = "cancel", rec.OrderID
+		case engine.JournalAmend:
+			ev.Op, ev.OrderID = "amend", rec.OrderID
+		default:
+			return fmt.Errorf("record %d: unknown op %d", rec.Index, rec.Op)
+		}
+		done := make(chan error, 1)
+		go func(rec engine.JournalRecord) { done <- apply(book, rec) }(rec)
+
+		var recTrades []engine.MatchResult
+		for applied := false; !applied; {
+			select {
+			case trade := <-trades:
+				recTrades = append(recTrades, trade)
+			case err := <-done:
+				if err != nil {
+					ev.Error = err.Error()
+				}
+				applied = true
+			}
+		}
+		for drained := false; !drained; {
+			select {
+			case trade := <-trades:
+				recTrades = append(recTrades, trade)
+			default:
+				drained = true
+			}
+		}
+		if err := emit(ev); err != nil {
+			return err
+		}
+		for i := range recTrades {
+			if err := emit(event{Index: rec.Index, Trade: &recTrades[i]}); err != nil {
+				return err
+			}
+		}
+
+		depth, err := book.Depth(opts.levels)
+		if err != nil {
+			return fmt.Errorf("record %d: depth: %w", rec.Index, err)
+		}
+		if err := emit(event{Index: rec.Index, Book: &depth}); err != nil {
+			return err
+		}
+	}
+
+	if diff != nil {
+		if msg := diff.finish(); msg != "" {
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// apply feeds one journaled command to the book.
+func apply(book *engine.OrderBook, rec engine.JournalRecord) error {
+	switch rec.Op {
+	case engine.JournalCancel:
+		return book.CancelOrder(rec.OrderID)
+	case engine.JournalAmend:
+		return book.AmendOrder(rec.OrderID, rec.Price, rec.Quantity)
+	default:
+		return book.SubmitOrder(*rec.Order)
+	}
+}
+
+func firstSymbol(records []engine.JournalRecord) string {
+	for _, rec := range records {
+		if rec.Op == engine.JournalAdd && rec.Order != nil {
+			return rec.Order.Symbol
+		}
+	}
+	return ""
+}
+
+// differ compares replay output line by line with a recorded run.
+type differ struct {
+	recorded *bufio.Scanner
+	line     int
+}
+
+func newDiffer(r io.Reader) *differ {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &differ{recorded: scanner}
+}
+
+func (d *differ) check(line []byte) string {
+	d.line++
+	if !d.recorded.Scan() {
+		return fmt.Sprintf("line %d: recorded run ended, replay produced\n  %s", d.line, line)
+	}
+	if want := d.recorded.Bytes(); string(want) != string(line) {
+		return fmt.Sprintf("line %d differs\n  recorded: %s\n  replay:   %s", d.line, want, line)
+	}
+	return ""
+}
+
+func (d *differ) finish() string {
+	if d.recorded.Scan() {
+		return fmt.Sprintf("line %d: replay ended, recorded run continues\n  %s", d.line+1, d.recorded.Bytes())
+	}
+	return ""
+}
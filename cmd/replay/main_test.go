@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"limitless/engine"
+)
+
+// testdata/LMT.journal was recorded by a book with testdata/fees.json and a
+// one minute dedup window; testdata/LMT.jsonl is its replay.
+func recordedSession(t *testing.T) ([]engine.JournalRecord, options) {
+	t.Helper()
+	records, err := engine.ReadJournal("testdata/LMT.journal")
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	fees, err := engine.ReadFeeSchedule("testdata/fees.json")
+	if err != nil {
+		t.Fatalf("read fees: %v", err)
+	}
+	return records, options{tick: 1, maxDepth: 10, levels: 5, fees: fees, dedupWindow: time.Minute}
+}
+
+func TestReplayMatchesRecordedRun(t *testing.T) {
+	records, opts := recordedSession(t)
+	recorded, err := os.ReadFile("testdata/LMT.jsonl")
+	if err != nil {
+		t.Fatalf("read recorded run: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := replay(records, opts, &out, newDiffer(bytes.NewReader(recorded))); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if out.String() != string(recorded) {
+		t.Fatalf("expected\n%s\ngot\n%s", recorded, out.String())
+	}
+}
+
+func TestReplayWithOtherConfigDiverges(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(*options)
+		want   string
+	}{
+		{"without fees", func(o *options) { o.fees = nil }, "line 4 differs"},
+		{"default dedup window", func(o *options) { o.dedupWindow = time.Hour }, "line 10 differs"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, opts := recordedSession(t)
+			tc.change(&opts)
+			recorded, err := os.Open("testdata/LMT.jsonl")
+			if err != nil {
+				t.Fatalf("open recorded run: %v", err)
+			}
+			defer recorded.Close()
+
+			var out bytes.Buffer
+			if err := replay(records, opts, &out, newDiffer(recorded)); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+				t.Fatalf("expected %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
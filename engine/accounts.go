@@ -0,0 +1,556 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Reasons attached to balance updates.
+const (
+	BalanceDeposit = "deposit"
+	BalanceReserve = "reserve"
+	BalanceRelease = "release"
+	BalanceFill    = "fill"
+)
+
+// Balance is an account's cash and per-symbol positions. The reserved parts
+// are held for working orders; the rest is available to new ones.
+type Balance struct {
+	Account      string
+	Cash         int64
+	ReservedCash int64
+	Positions    map[string]Holding
+}
+
+// Holding is an account's position in one symbol.
+type Holding struct {
+	Quantity int64
+	Reserved int64
+}
+
+// BalanceUpdate is published each time an account's balance changes.
+type BalanceUpdate struct {
+	Balance   Balance
+	Reason    string
+	OrderID   string
+	Timestamp time.Time
+}
+
+// Accounts keeps cash and positions for the owners trading on an Exchange.
+// Orders sent through it reserve cash (buys, at their limit price plus the
+// most they could pay in fees) or inventory (sells) when accepted. Reservations are resized from each
+// order's execution reports, settled on fills, and released once the order
+// is done.
+type Accounts struct {
+	ex       *Exchange
+	mu       sync.Mutex
+	accounts map[string]*account
+	orders   map[string]*reservation
+	updates  *fanout[BalanceUpdate]
+	wg       sync.WaitGroup
+}
+
+type account struct {
+	cash         int64
+	reservedCash int64
+	positions    map[string]*Holding
+}
+
+// reservation tracks what a working order holds: price*leaves of cash and
+// the fees on it for a buy, leaves of the symbol for a sell. A sell's fees
+// come out of its proceeds, which always cover them.
+type reservation struct {
+	owner  string
+	symbol string
+	side   Side
+	price  int64
+	leaves int64
+	feeBps float64 // the higher of the owner's maker and taker rates, if positive
+}
+
+// NewAccounts layers accounts over every book of ex. Orders already working
+// on its books, such as ones recovered from a journal, are reserved against
+// their owners' accounts as they stand; balances themselves are not
+// persisted, so deposits made afterwards should restore them. Its balance
+// stream closes once the exchange stops.
+func NewAccounts(ex *Exchange) *Accounts {
+	a := &Accounts{
+		ex:       ex,
+		accounts: make(map[string]*account),
+		orders:   make(map[string]*reservation),
+		updates:  newFanout[BalanceUpdate](256, OutputDrop),
+	}
+	for _, cfg := range ex.Instruments() {
+		book, _ := ex.Book(cfg.Symbol)
+		// Settlement must see every report, so it never drops one.
+		sub := book.SubscribeExecutionReports(SubscribeOptions{Policy: OutputSpill})
+		a.wg.Add(1)
+		go a.consume(sub.C)
+	}
+	a.restore()
+	go func() {
+		a.wg.Wait()
+		a.updates.close()
+	}()
+	return a
+}
+
+// restore reserves what each owned working order on the exchange still
+// holds, so its fills and cancels settle like those of orders sent through a.
+func (a *Accounts) restore() {
+	open, _ := a.ex.Orders("", ScopeOpen)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, status := range open {
+		order := status.Order
+		if order.Owner == "" {
+			continue
+		}
+		if _, working := a.orders[order.ID]; working {
+			continue
+		}
+		book, _ := a.ex.Book(order.Symbol)
+		r := &reservation{owner: order.Owner, symbol: order.Symbol, side: order.Side, feeBps: maxFeeBps(book.cfg.Fees, order.Owner, order.Symbol)}
+		a.open(order.Owner).resize(r, order.Price, status.LeavesQty)
+		a.orders[order.ID] = r
+	}
+}
+
+func (a *Accounts) consume(reports <-chan ExecutionReport) {
+	defer a.wg.Done()
+	for rep := range reports {
+		a.apply(rep)
+	}
+}
+
+// SubscribeBalances returns a stream of balance updates for every account.
+// Unread updates are dropped.
+func (a *Accounts) SubscribeBalances(opts SubscribeOptions) *Subscription[BalanceUpdate] {
+	return a.updates.subscribeWith(opts.Buffer, OutputDrop, nil)
+}
+
+// Deposit adds cash to an account, creating it if needed. A negative amount
+// withdraws, but never below what working orders have reserved.
+func (a *Accounts) Deposit(id string, cash int64) error {
+	if id == "" {
+		return errors.New("account id is required")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acct := a.open(id)
+	if acct.cash+cash < acct.reservedCash {
+		return fmt.Errorf("insufficient funds: available %d", acct.cash-acct.reservedCash)
+	}
+	acct.cash += cash
+	a.publish(id, acct, BalanceDeposit, "", time.Now())
+	return nil
+}
+
+// DepositPosition adds quantity of a symbol to an account, creating it if
+// needed. A negative quantity withdraws, but never below what working orders
+// have reserved.
+func (a *Accounts) DepositPosition(id, symbol string, qty int64) error {
+	if id == "" {
+		return errors.New("account id is required")
+	}
+	if _, err := a.ex.Book(symbol); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acct := a.open(id)
+	h := acct.holding(symbol)
+	if h.Quantity+qty < h.Reserved {
+		return fmt.Errorf("insufficient %s position: available %d", symbol, h.Quantity-h.Reserved)
+	}
+	h.Quantity += qty
+	a.publish(id, acct, BalanceDeposit, "", time.Now())
+	return nil
+}
+
+// Balance returns a copy of an account's balance.
+func (a *Accounts) Balance(id string) (Balance, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acct, ok := a.accounts[id]
+	if !ok {
+		return Balance{}, fmt.Errorf("unknown account %s", id)
+	}
+	return acct.balance(id), nil
+}
+
+// SubmitOrder reserves funds for an order and routes it to its book. The
+// order's Owner names the account. Market and stop buys must give Price as
+// the most they will pay; they are sent as limit and stop-limit orders at
+// that price so fills never cost more than was reserved, and a GTC one
+// becomes IOC so its remainder does not rest.
+func (a *Accounts) SubmitOrder(order Order) error {
+	order, r, err := a.reserve(order)
+	if err != nil {
+		return err
+	}
+	if err := a.ex.SubmitOrder(order); err != nil {
+		a.release(order, r)
+		return err
+	}
+	return nil
+}
+
+// SubmitOrders reserves funds for each order of a batch in turn, as
+// SubmitOrder does, and routes the funded ones on together.
+func (a *Accounts) SubmitOrders(orders []Order) []error {
+	errs := make([]error, len(orders))
+	var funded []Order
+	var indexes []int
+	var reserved []*reservation
+	for i, order := range orders {
+		order, r, err := a.reserve(order)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		funded = append(funded, order)
+		indexes = append(indexes, i)
+		reserved = append(reserved, r)
+	}
+	for j, err := range a.ex.SubmitOrders(funded) {
+		if err != nil {
+			a.release(funded[j], reserved[j])
+			errs[indexes[j]] = err
+		}
+	}
+	return errs
+}
+
+// reserve checks that an order is funded and holds its funds, returning the
+// order as it should be sent.
+func (a *Accounts) reserve(order Order) (Order, *reservation, error) {
+	if order.Owner == "" {
+		return order, nil, errors.New("order owner is required")
+	}
+	if order.Quantity <= 0 {
+		return order, nil, errors.New("order quantity must be positive")
+	}
+	book, err := a.ex.Book(order.Symbol)
+	if err != nil {
+		return order, nil, err
+	}
+	if order.Side == Buy && (order.Type == Market || order.Type == Stop) {
+		if order.Price <= 0 {
+			return order, nil, errors.New("market and stop buys need a price cap for the funds check")
+		}
+		if order.Type == Market {
+			order.Type = Limit
+		} else {
+			order.Type = StopLimit
+		}
+		if order.TimeInForce == GTC {
+			order.TimeInForce = IOC
+		}
+	}
+
+	now := book.Clock().Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acct, ok := a.accounts[order.Owner]
+	if !ok {
+		return order, nil, fmt.Errorf("unknown account %s", order.Owner)
+	}
+	if _, working := a.orders[order.ID]; working {
+		return order, nil, orderError(OrderDuplicateID, "order %s is already working", order.ID)
+	}
+	r := &reservation{owner: order.Owner, symbol: order.Symbol, side: order.Side, feeBps: maxFeeBps(book.cfg.Fees, order.Owner, order.Symbol)}
+	if err := acct.check(r, order.Price, order.Quantity); err != nil {
+		return order, nil, err
+	}
+	acct.resize(r, order.Price, order.Quantity)
+	a.orders[order.ID] = r
+	a.publish(order.Owner, acct, BalanceReserve, order.ID, now)
+	return order, r, nil
+}
+
+// release returns the funds held for an order its book turned away.
+func (a *Accounts) release(order Order, r *reservation) {
+	book, _ := a.ex.Book(order.Symbol)
+	now := book.Clock().Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.orders[order.ID] == r {
+		acct := a.accounts[order.Owner]
+		acct.resize(r, 0, 0)
+		delete(a.orders, order.ID)
+		a.publish(order.Owner, acct, BalanceRelease, order.ID, now)
+	}
+}
+
+// CancelOrder cancels an order; its reservation is released when the book
+// reports the cancel.
+func (a *Accounts) CancelOrder(symbol, id string) error {
+	return a.ex.CancelOrder(symbol, id)
+}
+
+// CancelOrders mass cancels orders; their reservations are released as the
+// book reports the cancels.
+func (a *Accounts) CancelOrders(filter CancelFilter) ([]Order, error) {
+	return a.ex.CancelOrders(filter)
+}
+
+// AmendOrder amends an order, first checking that the account can fund a
+// higher buy price or quantity.
+func (a *Accounts) AmendOrder(symbol, id string, price *int64, qty *int64) error {
+	book, err := a.ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	now := book.Clock().Now()
+	// The amended quantity includes what has already filled. Fills racing
+	// the amend only leave more reserved than needed until they settle.
+	var filled int64
+	if qty != nil {
+		if status, err := book.OrderStatus(id); err == nil {
+			filled = status.CumQty
+		}
+	}
+
+	a.mu.Lock()
+	r, ok := a.orders[id]
+	var acct *account
+	oldPrice, oldLeaves := int64(0), int64(0)
+	if ok {
+		acct = a.accounts[r.owner]
+		oldPrice, oldLeaves = r.price, r.leaves
+		newPrice, newLeaves := oldPrice, oldLeaves
+		if price != nil && *price > 0 {
+			newPrice = *price
+		}
+		if qty != nil && *qty > filled {
+			newLeaves = *qty - filled
+		}
+		if err := acct.check(r, newPrice, newLeaves); err != nil {
+			a.mu.Unlock()
+			return err
+		}
+		acct.resize(r, newPrice, newLeaves)
+		a.publish(r.owner, acct, BalanceReserve, id, now)
+	}
+	a.mu.Unlock()
+
+	if err := book.AmendOrder(id, price, qty); err != nil {
+		if ok {
+			a.mu.Lock()
+			if a.orders[id] == r {
+				acct.resize(r, oldPrice, oldLeaves)
+				a.publish(r.owner, acct, BalanceRelease, id, now)
+			}
+			a.mu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+// ReplaceOrder cancel-replaces an order, first checking that the account can
+// fund the replacement with what the original holds counted as available.
+// Until the original's cancel is reported both stay reserved.
+func (a *Accounts) ReplaceOrder(symbol, id, newID string, price *int64, qty *int64) error {
+	book, err := a.ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	now := book.Clock().Now()
+
+	a.mu.Lock()
+	r, ok := a.orders[id]
+	var acct *account
+	var nr *reservation
+	if ok {
+		if _, working := a.orders[newID]; working {
+			a.mu.Unlock()
+			return fmt.Errorf("order %s is already working", newID)
+		}
+		acct = a.accounts[r.owner]
+		newPrice, newLeaves := r.price, r.leaves
+		if price != nil {
+			newPrice = *price
+		}
+		if qty != nil {
+			newLeaves = *qty
+		}
+		if err := acct.check(r, newPrice, newLeaves); err != nil {
+			a.mu.Unlock()
+			return err
+		}
+		nr = &reservation{owner: r.owner, symbol: r.symbol, side: r.side, feeBps: r.feeBps}
+		acct.resize(nr, newPrice, newLeaves)
+		a.orders[newID] = nr
+		a.publish(r.owner, acct, BalanceReserve, newID, now)
+	}
+	a.mu.Unlock()
+
+	if err := book.ReplaceOrder(id, newID, price, qty); err != nil {
+		if ok {
+			a.mu.Lock()
+			if a.orders[newID] == nr {
+				acct.resize(nr, 0, 0)
+				delete(a.orders, newID)
+				a.publish(r.owner, acct, BalanceRelease, newID, now)
+			}
+			a.mu.Unlock()
+		}
+		return err
+	}
+	return nil
+}
+
+// apply settles one execution report against the order's reservation.
+func (a *Accounts) apply(rep ExecutionReport) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.orders[rep.OrderID]
+	if !ok {
+		a.settleUntracked(rep)
+		return
+	}
+	if r.owner != rep.Owner || r.symbol != rep.Symbol {
+		return
+	}
+	acct := a.accounts[r.owner]
+
+	reason := BalanceReserve
+	switch rep.State {
+	case StatePartiallyFilled, StateFilled:
+		acct.settle(rep)
+		// Resizing releases the part of a buy's fee reservation the fill
+		// left over.
+		acct.resize(r, rep.Price, rep.LeavesQty)
+		reason = BalanceFill
+	case StateCanceled, StateRejected, StateExpired:
+		acct.resize(r, 0, 0)
+		reason = BalanceRelease
+	default:
+		if r.price == rep.Price && r.leaves == rep.LeavesQty {
+			return
+		}
+		acct.resize(r, rep.Price, rep.LeavesQty)
+	}
+	if r.leaves == 0 {
+		delete(a.orders, rep.OrderID)
+	}
+	a.publish(r.owner, acct, reason, rep.OrderID, rep.Timestamp)
+}
+
+// settleUntracked moves cash and position for a fill of an order that holds
+// no reservation, one its owner's account did not fund through a.
+func (a *Accounts) settleUntracked(rep ExecutionReport) {
+	if rep.State != StatePartiallyFilled && rep.State != StateFilled {
+		return
+	}
+	acct, ok := a.accounts[rep.Owner]
+	if !ok {
+		return
+	}
+	acct.settle(rep)
+	a.publish(rep.Owner, acct, BalanceFill, rep.OrderID, rep.Timestamp)
+}
+
+func (a *Accounts) open(id string) *account {
+	acct, ok := a.accounts[id]
+	if !ok {
+		acct = &account{positions: make(map[string]*Holding)}
+		a.accounts[id] = acct
+	}
+	return acct
+}
+
+func (a *Accounts) publish(id string, acct *account, reason, orderID string, at time.Time) {
+	a.updates.publish(BalanceUpdate{Balance: acct.balance(id), Reason: reason, OrderID: orderID, Timestamp: at})
+}
+
+func (acct *account) holding(symbol string) *Holding {
+	h, ok := acct.positions[symbol]
+	if !ok {
+		h = &Holding{}
+		acct.positions[symbol] = h
+	}
+	return h
+}
+
+// settle moves cash and position for a fill report. Fees come out of cash;
+// a rebate is a negative fee.
+func (acct *account) settle(rep ExecutionReport) {
+	notional := rep.LastPrice * rep.LastQty
+	h := acct.holding(rep.Symbol)
+	if rep.Side == Buy {
+		acct.cash -= notional
+		h.Quantity += rep.LastQty
+	} else {
+		acct.cash += notional
+		h.Quantity -= rep.LastQty
+	}
+	acct.cash -= rep.Fee
+}
+
+// check reports whether the account can fund r at price and leaves, counting
+// what r already holds as available.
+func (acct *account) check(r *reservation, price, leaves int64) error {
+	if r.side == Buy {
+		need := r.cash(price, leaves) - r.cash(r.price, r.leaves)
+		if available := acct.cash - acct.reservedCash; need > available {
+			return fmt.Errorf("insufficient funds: need %d, available %d", r.cash(price, leaves), available+r.cash(r.price, r.leaves))
+		}
+		return nil
+	}
+	h := acct.holding(r.symbol)
+	if available := h.Quantity - h.Reserved; leaves-r.leaves > available {
+		return fmt.Errorf("insufficient %s position: need %d, available %d", r.symbol, leaves, available+r.leaves)
+	}
+	return nil
+}
+
+// resize moves r to hold the cash for leaves at price, or leaves of
+// inventory.
+func (acct *account) resize(r *reservation, price, leaves int64) {
+	if r.side == Buy {
+		acct.reservedCash += r.cash(price, leaves) - r.cash(r.price, r.leaves)
+	} else {
+		acct.holding(r.symbol).Reserved += leaves - r.leaves
+	}
+	r.price, r.leaves = price, leaves
+}
+
+// cash returns what a buy of leaves at price holds: the notional and the
+// most it can pay in fees. Each fill's fee is rounded to the nearest unit,
+// so every fill, at most one per lot, may cost half a unit over the rate.
+func (r *reservation) cash(price, leaves int64) int64 {
+	notional := price * leaves
+	if r.feeBps <= 0 || leaves == 0 {
+		return notional
+	}
+	return notional + int64(math.Ceil(float64(notional)*r.feeBps/10000+float64(leaves)/2))
+}
+
+// maxFeeBps returns the highest rate owner can be charged on symbol, or zero
+// if it only earns rebates.
+func maxFeeBps(fees *FeeSchedule, owner, symbol string) float64 {
+	if fees == nil {
+		return 0
+	}
+	rates := fees.Rates(owner, symbol)
+	return math.Max(0, math.Max(rates.MakerBps, rates.TakerBps))
+}
+
+func (acct *account) balance(id string) Balance {
+	b := Balance{
+		Account:      id,
+		Cash:         acct.cash,
+		ReservedCash: acct.reservedCash,
+		Positions:    make(map[string]Holding, len(acct.positions)),
+	}
+	for symbol, h := range acct.positions {
+		b.Positions[symbol] = *h
+	}
+	return b
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAccounts(t *testing.T) (*Exchange, *Accounts) {
+	t.Helper()
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "ACC", TickSize: 1, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0))}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	return ex, NewAccounts(ex)
+}
+
+// waitBalance polls until the account's balance satisfies ok, since reports
+// are settled on their own goroutine.
+func waitBalance(t *testing.T, a *Accounts, id string, ok func(Balance) bool) Balance {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, err := a.Balance(id)
+		if err != nil {
+			t.Fatalf("balance %s: %v", id, err)
+		}
+		if ok(b) {
+			return b
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting on %s, last balance %+v", id, b)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAccountsRejectOverdraw(t *testing.T) {
+	ex, accounts := newTestAccounts(t)
+	defer ex.Stop()
+
+	_ = accounts.Deposit("alice", 1000)
+	_ = accounts.DepositPosition("bob", "ACC", 5)
+
+	err := accounts.SubmitOrder(Order{ID: "b1", Symbol: "ACC", Side: Buy, Type: Limit, Price: 100, Quantity: 11, Owner: "alice"})
+	if err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	err = accounts.SubmitOrder(Order{ID: "s1", Symbol: "ACC", Side: Sell, Type: Limit, Price: 100, Quantity: 6, Owner: "bob"})
+	if err == nil || !strings.Contains(err.Error(), "insufficient ACC position") {
+		t.Fatalf("expected insufficient position, got %v", err)
+	}
+	if err := accounts.SubmitOrder(Order{ID: "m1", Symbol: "ACC", Side: Buy, Type: Market, Quantity: 1, Owner: "alice"}); err == nil {
+		t.Fatalf("expected a market buy without a price cap to be rejected")
+	}
+
+	if err := accounts.SubmitOrder(Order{ID: "b2", Symbol: "ACC", Side: Buy, Type: Limit, Price: 100, Quantity: 10, Owner: "alice"}); err != nil {
+		t.Fatalf("submit b2: %v", err)
+	}
+	if b, _ := accounts.Balance("alice"); b.ReservedCash != 1000 {
+		t.Fatalf("expected 1000 reserved, got %+v", b)
+	}
+	if err := accounts.SubmitOrder(Order{ID: "b3", Symbol: "ACC", Side: Buy, Type: Limit, Price: 1, Quantity: 1, Owner: "alice"}); err == nil {
+		t.Fatalf("expected reserved cash to be unavailable")
+	}
+
+	if err := accounts.CancelOrder("ACC", "b2"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	waitBalance(t, accounts, "alice", func(b Balance) bool { return b.ReservedCash == 0 && b.Cash == 1000 })
+}
+
+func TestAccountsSettleFills(t *testing.T) {
+	ex, accounts := newTestAccounts(t)
+	defer ex.Stop()
+
+	_ = accounts.Deposit("alice", 1000)
+	_ = accounts.DepositPosition("bob", "ACC", 5)
+
+	if err := accounts.SubmitOrder(Order{ID: "s1", Symbol: "ACC", Side: Sell, Type: Limit, Price: 90, Quantity: 5, Owner: "bob"}); err != nil {
+		t.Fatalf("submit s1: %v", err)
+	}
+	// The buy reserves 3*100 but trades at 90; the difference comes back.
+	if err := accounts.SubmitOrder(Order{ID: "b1", Symbol: "ACC", Side: Buy, Type: Limit, Price: 100, Quantity: 3, Owner: "alice"}); err != nil {
+		t.Fatalf("submit b1: %v", err)
+	}
+
+	alice := waitBalance(t, accounts, "alice", func(b Balance) bool { return b.Positions["ACC"].Quantity == 3 })
+	if alice.Cash != 730 || alice.ReservedCash != 0 {
+		t.Fatalf("unexpected buyer balance %+v", alice)
+	}
+	bob := waitBalance(t, accounts, "bob", func(b Balance) bool { return b.Cash == 270 })
+	if bob.Positions["ACC"] != (Holding{Quantity: 2, Reserved: 2}) {
+		t.Fatalf("unexpected seller balance %+v", bob)
+	}
+
+	if err := accounts.CancelOrder("ACC", "s1"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	waitBalance(t, accounts, "bob", func(b Balance) bool { return b.Positions["ACC"] == Holding{Quantity: 2} })
+}
+
+func TestAccountsRestoreReservationsAfterRestart(t *testing.T) {
+	cfg := OrderBookConfig{Symbol: "ACC", TickSize: 1, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0)),
+		Journal: JournalConfig{Path: filepath.Join(t.TempDir(), "ACC.journal")}}
+	open := func() (*Exchange, *Accounts) {
+		ex, err := NewExchange([]OrderBookConfig{cfg})
+		if err != nil {
+			t.Fatalf("new exchange: %v", err)
+		}
+		accounts := NewAccounts(ex)
+		_ = accounts.Deposit("alice", 1000)
+		_ = accounts.DepositPosition("bob", "ACC", 5)
+		return ex, accounts
+	}
+
+	ex, accounts := open()
+	if err := accounts.SubmitOrder(Order{ID: "s1", Symbol: "ACC", Side: Sell, Type: Limit, Price: 90, Quantity: 5, Owner: "bob"}); err != nil {
+		t.Fatalf("submit s1: %v", err)
+	}
+	ex.Stop()
+
+	ex, accounts = open()
+	defer ex.Stop()
+	if bob, _ := accounts.Balance("bob"); bob.Positions["ACC"] != (Holding{Quantity: 5, Reserved: 5}) {
+		t.Fatalf("the recovered sell should hold its inventory, got %+v", bob)
+	}
+	if err := accounts.DepositPosition("bob", "ACC", -1); err == nil {
+		t.Fatalf("withdrawing inventory the recovered sell holds should fail")
+	}
+
+	// The buy bypasses the accounts, so only its owner ties its fill to one.
+	if err := ex.SubmitOrder(Order{ID: "b1", Symbol: "ACC", Side: Buy, Type: Limit, Price: 100, Quantity: 3, Owner: "alice"}); err != nil {
+		t.Fatalf("submit b1: %v", err)
+	}
+	bob := waitBalance(t, accounts, "bob", func(b Balance) bool { return b.Cash == 270 })
+	if bob.Positions["ACC"] != (Holding{Quantity: 2, Reserved: 2}) {
+		t.Fatalf("unexpected seller balance %+v", bob)
+	}
+	alice := waitBalance(t, accounts, "alice", func(b Balance) bool { return b.Positions["ACC"].Quantity == 3 })
+	if alice.Cash != 730 || alice.ReservedCash != 0 {
+		t.Fatalf("unexpected buyer balance %+v", alice)
+	}
+}
@@ -0,0 +1,65 @@
+package engine
+
+import "sort"
+
+// flush returns the current state of every dirty level, best first, and
+// clears the set.
+func (s *bookSide) flush() []PriceLevel {
+	if len(s.dirty) == 0 {
+		return nil
+	}
+	out := make([]PriceLevel, 0, len(s.dirty))
+	for price := range s.dirty {
+		if level, ok := s.levels[price]; ok {
+			out = append(out, level.aggregate())
+		} else {
+			out = append(out, PriceLevel{Price: price})
+		}
+		delete(s.dirty, price)
+	}
+	sort.Slice(out, func(i, j int) bool { return s.outranks(out[i].Price, out[j].Price) })
+	return out
+}
+
+// top returns up to n levels ordered best first; n <= 0 returns every level.
+func (s *bookSide) top(n int) []PriceLevel {
+	size := len(s.levels)
+	if n > 0 && n < size {
+		size = n
+	}
+	out := make([]PriceLevel, 0, size)
+	for level := s.best; level != nil && len(out) < size; level = level.worse {
+		out = append(out, level.aggregate())
+	}
+	return out
+}
+
+func (l *priceLevel) aggregate() PriceLevel {
+	return PriceLevel{Price: l.price, Quantity: l.quantity, Orders: l.orders}
+}
+
+// publishDepth emits the levels touched since the last update. Updates are
+// dropped rather than stalling the book; consumers detect gaps by sequence.
+func (ob *OrderBook) publishDepth() {
+	if len(ob.bids.dirty) == 0 && len(ob.asks.dirty) == 0 {
+		return
+	}
+	ob.depthSeq++
+	update := DepthUpdate{
+		Symbol:   ob.cfg.Symbol,
+		Sequence: ob.depthSeq,
+		Bids:     ob.bids.flush(),
+		Asks:     ob.asks.flush(),
+	}
+	ob.depthFeed.publish(update)
+}
+
+func (ob *OrderBook) depthView(n int) Depth {
+	ob.publishDepth()
+	return Depth{
+		Symbol:   ob.cfg.Symbol,
+		Sequence: ob.depthSeq,
+		Bids:     ob.bids.top(n),
+		Asks:     ob.asks.top(n),
+	}
+}
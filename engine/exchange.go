@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Exchange owns one OrderBook per symbol, routes requests by symbol, and
+// merges every book's output into a single set of streams.
+type Exchange struct {
+	books   map[string]*OrderBook
+	configs []OrderBookConfig
+	trades  chan MatchResult
+	// tradesRead is set by the first Trades call; until then a full trades
+	// stream drops and counts in tradeDrops rather than stalling matching.
+	tradesRead atomic.Bool
+	tradeDrops map[string]*atomic.Uint64
+	updates    chan BookView
+	reports    chan ExecutionReport
+	depth      chan DepthUpdate
+	l3         chan L3Event
+	wg         sync.WaitGroup
+	stopOnce   sync.Once
+}
+
+// NewExchange builds a book for each configured instrument.
+func NewExchange(cfgs []OrderBookConfig) (*Exchange, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("exchange needs at least one instrument")
+	}
+
+	ex := &Exchange{
+		books:      make(map[string]*OrderBook, len(cfgs)),
+		tradeDrops: make(map[string]*atomic.Uint64, len(cfgs)),
+		trades:     make(chan MatchResult, 1024),
+		updates:    make(chan BookView, 16*len(cfgs)),
+		reports:    make(chan ExecutionReport, 1024),
+		depth:      make(chan DepthUpdate, 256),
+		l3:         make(chan L3Event, 4096),
+	}
+	for _, cfg := range cfgs {
+		if cfg.Symbol == "" {
+			return nil, errors.New("instrument symbol is required")
+		}
+		if _, ok := ex.books[cfg.Symbol]; ok {
+			return nil, fmt.Errorf("duplicate instrument %s", cfg.Symbol)
+		}
+		ex.books[cfg.Symbol] = nil
+	}
+
+	for _, cfg := range cfgs {
+		book, err := OpenOrderBook(cfg)
+		if err != nil {
+			for _, built := range ex.books {
+				if built != nil {
+					built.Stop()
+				}
+			}
+			return nil, fmt.Errorf("open %s: %w", cfg.Symbol, err)
+		}
+		ex.books[cfg.Symbol] = book
+		ex.configs = append(ex.configs, cfg)
+		ex.tradeDrops[cfg.Symbol] = new(atomic.Uint64)
+		// The book's own Trades stream would go unread, so the exchange
+		// replaces it with a subscription that honours the policy in full.
+		book.trades.Unsubscribe()
+		ex.wg.Add(5)
+		go ex.forwardTrades(book.SubscribeTrades(SubscribeOptions{Policy: cfg.TradePolicy}).C, ex.tradeDrops[cfg.Symbol])
+		go forward(&ex.wg, book.BookUpdates(), ex.updates)
+		go forward(&ex.wg, book.ExecutionReports(), ex.reports)
+		go forward(&ex.wg, book.DepthUpdates(), ex.depth)
+		go forward(&ex.wg, book.L3Events(), ex.l3)
+	}
+
+	go func() {
+		ex.wg.Wait()
+		close(ex.trades)
+		close(ex.updates)
+		close(ex.reports)
+		close(ex.depth)
+		close(ex.l3)
+	}()
+
+	return ex, nil
+}
+
+func forward[T any](wg *sync.WaitGroup, in <-chan T, out chan<- T) {
+	defer wg.Done()
+	for value := range in {
+		out <- value
+	}
+}
+
+// forwardTrades moves one book's trades into the merged stream. Until Trades
+// is first called nothing has to read that stream, so when it is full a trade
+// is dropped and counted rather than stalling the book. Once it has a reader
+// the exchange waits for it, which is how OutputBlock reaches matching.
+func (ex *Exchange) forwardTrades(in <-chan MatchResult, dropped *atomic.Uint64) {
+	defer ex.wg.Done()
+	for trade := range in {
+		if ex.tradesRead.Load() {
+			ex.trades <- trade
+			continue
+		}
+		select {
+		case ex.trades <- trade:
+		default:
+			dropped.Add(1)
+		}
+	}
+}
+
+// Instruments returns the configuration of every book in listing order.
+func (ex *Exchange) Instruments() []OrderBookConfig {
+	out := make([]OrderBookConfig, len(ex.configs))
+	copy(out, ex.configs)
+	return out
+}
+
+// Book returns the order book for a symbol.
+func (ex *Exchange) Book(symbol string) (*OrderBook, error) {
+	book, ok := ex.books[symbol]
+	if !ok {
+		return nil, fmt.Errorf("unknown symbol %s", symbol)
+	}
+	return book, nil
+}
+
+// SubmitOrder routes a new order to the book for its symbol.
+func (ex *Exchange) SubmitOrder(order Order) error {
+	book, err := ex.Book(order.Symbol)
+	if err != nil {
+		return err
+	}
+	return book.SubmitOrder(order)
+}
+
+// SubmitOrders routes a batch of new orders, each book's share of it as a
+// single request, and returns an error, or nil, per order.
+func (ex *Exchange) SubmitOrders(orders []Order) []error {
+	errs := make([]error, len(orders))
+	var symbols []string
+	bySymbol := make(map[string][]int)
+	for i, order := range orders {
+		if _, err := ex.Book(order.Symbol); err != nil {
+			errs[i] = err
+			continue
+		}
+		if _, ok := bySymbol[order.Symbol]; !ok {
+			symbols = append(symbols, order.Symbol)
+		}
+		bySymbol[order.Symbol] = append(bySymbol[order.Symbol], i)
+	}
+
+	for _, symbol := range symbols {
+		indexes := bySymbol[symbol]
+		batch := make([]Order, len(indexes))
+		for j, i := range indexes {
+			batch[j] = orders[i]
+		}
+		for j, err := range ex.books[symbol].SubmitOrders(batch) {
+			errs[indexes[j]] = err
+		}
+	}
+	return errs
+}
+
+// CancelOrder cancels an order on the given symbol's book.
+func (ex *Exchange) CancelOrder(symbol, id string) error {
+	book, err := ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	return book.CancelOrder(id)
+}
+
+// CancelOrders mass cancels the orders matching filter on its symbol's book,
+// or on every book in listing order if it names none.
+func (ex *Exchange) CancelOrders(filter CancelFilter) ([]Order, error) {
+	if filter.Symbol != "" {
+		book, err := ex.Book(filter.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		return book.CancelOrders(filter)
+	}
+	var out []Order
+	for _, cfg := range ex.configs {
+		canceled, err := ex.books[cfg.Symbol].CancelOrders(filter)
+		out = append(out, canceled...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// AmendOrder amends an order on the given symbol's book.
+func (ex *Exchange) AmendOrder(symbol, id string, price *int64, qty *int64) error {
+	book, err := ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	return book.AmendOrder(id, price, qty)
+}
+
+// ReplaceOrder cancel-replaces an order on the given symbol's book.
+func (ex *Exchange) ReplaceOrder(symbol, id, newID string, price *int64, qty *int64) error {
+	book, err := ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	return book.ReplaceOrder(id, newID, price, qty)
+}
+
+// OrderStatus looks an order up on the given symbol's book.
+func (ex *Exchange) OrderStatus(symbol, id string) (OrderStatus, error) {
+	book, err := ex.Book(symbol)
+	if err != nil {
+		return OrderStatus{}, err
+	}
+	return book.OrderStatus(id)
+}
+
+// Orders lists the orders of owner (everyone's if empty) on every book, in
+// listing order.
+func (ex *Exchange) Orders(owner string, scope OrderScope) ([]OrderStatus, error) {
+	var out []OrderStatus
+	for _, cfg := range ex.configs {
+		orders, err := ex.books[cfg.Symbol].Orders(owner, scope)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, orders...)
+	}
+	return out, nil
+}
+
+// Trades exposes executed trades from every book. The first call makes the
+// caller the stream's reader: from then on a full stream applies each book's
+// TradePolicy, so under OutputBlock matching waits until the caller reads.
+// Before it, up to 1024 trades are kept and later ones are dropped and
+// counted in OutputStats.
+func (ex *Exchange) Trades() <-chan MatchResult {
+	ex.tradesRead.Store(true)
+	return ex.trades
+}
+
+// BookUpdates exposes top-of-book updates from every book.
+func (ex *Exchange) BookUpdates() <-chan BookView {
+	return ex.updates
+}
+
+// ExecutionReports exposes order lifecycle events from every book.
+func (ex *Exchange) ExecutionReports() <-chan ExecutionReport {
+	return ex.reports
+}
+
+// DepthUpdates exposes price level changes from every book. Sequences are
+// per symbol.
+func (ex *Exchange) DepthUpdates() <-chan DepthUpdate {
+	return ex.depth
+}
+
+// L3Events exposes the market-by-order feed from every book. Sequences are
+// per symbol.
+func (ex *Exchange) L3Events() <-chan L3Event {
+	return ex.l3
+}
+
+// OutputStats reports dropped events per symbol.
+func (ex *Exchange) OutputStats() map[string]OutputStats {
+	stats := make(map[string]OutputStats, len(ex.books))
+	for symbol, book := range ex.books {
+		out := book.OutputStats()
+		out.Trades += ex.tradeDrops[symbol].Load()
+		stats[symbol] = out
+	}
+	return stats
+}
+
+// Stop terminates every book; the merged streams close once drained.
+func (ex *Exchange) Stop() {
+	ex.stopOnce.Do(func() {
+		for _, book := range ex.books {
+			book.Stop()
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExchangeRoutesBySymbol(t *testing.T) {
+	ex, err := NewExchange([]OrderBookConfig{
+		{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10},
+		{Symbol: "ETHUSD", TickSize: 1, MaxDepth: 10},
+	})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+
+	if err := ex.SubmitOrder(Order{ID: "eth-ask", Symbol: "ETHUSD", Side: Sell, Type: Limit, Price: 11, Quantity: 1}); err != nil {
+		t.Fatalf("eth order should use its own tick size: %v", err)
+	}
+	if err := ex.SubmitOrder(Order{ID: "btc-ask", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 11, Quantity: 1}); err == nil {
+		t.Fatalf("btc order off its tick size should be rejected")
+	}
+	if err := ex.SubmitOrder(Order{ID: "doge", Symbol: "DOGEUSD", Side: Sell, Type: Limit, Price: 1, Quantity: 1}); err == nil {
+		t.Fatalf("unknown symbol should be rejected")
+	}
+	if err := ex.SubmitOrder(Order{ID: "eth-bid", Symbol: "ETHUSD", Side: Buy, Type: Market, Quantity: 1}); err != nil {
+		t.Fatalf("eth market order: %v", err)
+	}
+
+	trade := <-ex.Trades()
+	if trade.Symbol != "ETHUSD" || trade.SellOrderID != "eth-ask" {
+		t.Fatalf("unexpected trade %+v", trade)
+	}
+	if err := ex.CancelOrder("BTCUSD", "eth-ask"); err == nil {
+		t.Fatalf("cancel should be scoped to the symbol's book")
+	}
+
+	if _, err := NewExchange([]OrderBookConfig{{Symbol: "X", TickSize: 1}, {Symbol: "X", TickSize: 1}}); err == nil {
+		t.Fatalf("duplicate instruments should be rejected")
+	}
+}
+
+func TestExchangeUnreadTradesNeverStallMatching(t *testing.T) {
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+
+	const trades = 1500
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < trades; i++ {
+			if err := ex.SubmitOrder(Order{ID: fmt.Sprintf("ask%d", i), Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 10, Quantity: 1}); err != nil {
+				done <- err
+				return
+			}
+			if err := ex.SubmitOrder(Order{ID: fmt.Sprintf("bid%d", i), Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("matching stalled on the unread trades stream")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ex.OutputStats()["BTCUSD"].Trades == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("trades beyond the stream's buffer should be counted as dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if trade := <-ex.Trades(); trade.SellOrderID != "ask0" {
+		t.Fatalf("the stream should keep the earliest trades, got %+v", trade)
+	}
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FeeRates are the fees charged on a fill, in basis points of its notional
+// (price times quantity). A negative rate is a rebate.
+type FeeRates struct {
+	MakerBps float64
+	TakerBps float64
+}
+
+// Fee returns the fee on notional, rounded to the nearest unit.
+func (r FeeRates) Fee(notional int64, maker bool) int64 {
+	bps := r.TakerBps
+	if maker {
+		bps = r.MakerBps
+	}
+	return int64(math.Round(float64(notional) * bps / 10000))
+}
+
+// FeeSchedule prices fills by account tier and symbol. It must not change
+// once books use it.
+type FeeSchedule struct {
+	// Tiers holds the rates of each tier.
+	Tiers map[string]FeeRates
+	// Symbols overrides Tiers for a symbol, by tier.
+	Symbols map[string]map[string]FeeRates
+	// Accounts assigns owners to tiers; everyone else is in DefaultTier.
+	Accounts    map[string]string
+	DefaultTier string
+}
+
+// Rates returns the rates that apply to owner on symbol.
+func (s *FeeSchedule) Rates(owner, symbol string) FeeRates {
+	tier, ok := s.Accounts[owner]
+	if !ok {
+		tier = s.DefaultTier
+	}
+	if rates, ok := s.Symbols[symbol][tier]; ok {
+		return rates
+	}
+	return s.Tiers[tier]
+}
+
+type feeRatesFile struct {
+	MakerBps float64 `json:"makerBps"`
+	TakerBps float64 `json:"takerBps"`
+}
+
+type feeScheduleFile struct {
+	DefaultTier string                             `json:"defaultTier"`
+	Tiers       map[string]feeRatesFile            `json:"tiers"`
+	Symbols     map[string]map[string]feeRatesFile `json:"symbols"`
+	Accounts    map[string]string                  `json:"accounts"`
+}
+
+// ReadFeeSchedule reads a JSON file of tier rates in basis points, per-symbol
+// overrides by tier, and the tier of each account. Accounts not listed are
+// in defaultTier, which must have rates.
+func ReadFeeSchedule(path string) (*FeeSchedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fees: %w", err)
+	}
+	var file feeScheduleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse fees %s: %w", path, err)
+	}
+	if _, ok := file.Tiers[file.DefaultTier]; !ok {
+		return nil, fmt.Errorf("fees %s: default tier %q has no rates", path, file.DefaultTier)
+	}
+	for account, tier := range file.Accounts {
+		if _, ok := file.Tiers[tier]; !ok {
+			return nil, fmt.Errorf("fees %s: account %q has unknown tier %q", path, account, tier)
+		}
+	}
+
+	schedule := &FeeSchedule{
+		Tiers:       make(map[string]FeeRates, len(file.Tiers)),
+		Symbols:     make(map[string]map[string]FeeRates, len(file.Symbols)),
+		Accounts:    file.Accounts,
+		DefaultTier: file.DefaultTier,
+	}
+	for tier, rates := range file.Tiers {
+		schedule.Tiers[tier] = FeeRates(rates)
+	}
+	for symbol, tiers := range file.Symbols {
+		schedule.Symbols[symbol] = make(map[string]FeeRates, len(tiers))
+		for tier, rates := range tiers {
+			schedule.Symbols[symbol][tier] = FeeRates(rates)
+		}
+	}
+	return schedule, nil
+}
+
+// fee returns what owner pays for a fill of notional on symbol; a book with
+// no schedule charges nothing.
+func (s *FeeSchedule) fee(owner, symbol string, notional int64, maker bool) int64 {
+	if s == nil {
+		return 0
+	}
+	return s.Rates(owner, symbol).Fee(notional, maker)
+}
+
+// FeeTotal sums one account's fees on one UTC day.
+type FeeTotal struct {
+	Account  string
+	Day      string // YYYY-MM-DD
+	Fills    int
+	Notional int64
+	Paid     int64 // positive fees charged
+	Rebated  int64 // rebates credited, as a positive amount
+	Net      int64 // Paid - Rebated
+}
+
+// FeeLedger totals the fees every owner pays, by day, from the execution
+// reports of an Exchange's books.
+type FeeLedger struct {
+	mu     sync.Mutex
+	totals map[[2]string]*FeeTotal
+}
+
+// NewFeeLedger starts recording fees for every book of ex.
+func NewFeeLedger(ex *Exchange) *FeeLedger {
+	l := &FeeLedger{totals: make(map[[2]string]*FeeTotal)}
+	for _, inst := range ex.Instruments() {
+		book, _ := ex.Book(inst.Symbol)
+		sub := book.SubscribeExecutionReports(SubscribeOptions{Policy: OutputSpill})
+		go func() {
+			for rep := range sub.C {
+				l.record(rep)
+			}
+		}()
+	}
+	return l
+}
+
+func (l *FeeLedger) record(rep ExecutionReport) {
+	if rep.LastQty == 0 || rep.Owner == "" {
+		return
+	}
+	day := rep.Timestamp.UTC().Format("2006-01-02")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := [2]string{rep.Owner, day}
+	total, ok := l.totals[key]
+	if !ok {
+		total = &FeeTotal{Account: rep.Owner, Day: day}
+		l.totals[key] = total
+	}
+	total.Fills++
+	total.Notional += rep.LastPrice * rep.LastQty
+	if rep.Fee >= 0 {
+		total.Paid += rep.Fee
+	} else {
+		total.Rebated -= rep.Fee
+	}
+	total.Net += rep.Fee
+}
+
+// Totals returns the daily totals for account, or for every account if it is
+// empty, between the from and to days inclusive (YYYY-MM-DD; empty means
+// unbounded), ordered by account and day.
+func (l *FeeLedger) Totals(account, from, to string) []FeeTotal {
+	l.mu.Lock()
+	out := make([]FeeTotal, 0, len(l.totals))
+	for _, total := range l.totals {
+		if account != "" && total.Account != account {
+			continue
+		}
+		if (from != "" && total.Day < from) || (to != "" && total.Day > to) {
+			continue
+		}
+		out = append(out, *total)
+	}
+	l.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Account != out[j].Account {
+			return out[i].Account < out[j].Account
+		}
+		return out[i].Day < out[j].Day
+	})
+	return out
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeesChargeTakersAndRebateMakers(t *testing.T) {
+	fees := &FeeSchedule{
+		Tiers: map[string]FeeRates{
+			"retail": {MakerBps: 10, TakerBps: 30},
+			"mm":     {MakerBps: -5, TakerBps: 20},
+		},
+		Symbols:     map[string]map[string]FeeRates{"FEE": {"retail": {MakerBps: 10, TakerBps: 25}}},
+		Accounts:    map[string]string{"maker": "mm"},
+		DefaultTier: "retail",
+	}
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "FEE", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)), Fees: fees}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+	accounts := NewAccounts(ex)
+	ledger := NewFeeLedger(ex)
+
+	_ = accounts.DepositPosition("maker", "FEE", 10)
+	_ = accounts.Deposit("taker", 10000)
+	if err := accounts.SubmitOrder(Order{ID: "s1", Symbol: "FEE", Side: Sell, Type: Limit, Price: 200, Quantity: 10, Owner: "maker"}); err != nil {
+		t.Fatalf("submit s1: %v", err)
+	}
+	if err := accounts.SubmitOrder(Order{ID: "b1", Symbol: "FEE", Side: Buy, Type: Limit, Price: 200, Quantity: 10, Owner: "taker"}); err != nil {
+		t.Fatalf("submit b1: %v", err)
+	}
+
+	// 2000 notional: the buyer pays 25bps on FEE, the maker earns 5bps back.
+	trade := <-ex.Trades()
+	if trade.BuyFee != 5 || trade.SellFee != -1 {
+		t.Fatalf("unexpected trade fees %+v", trade)
+	}
+	taker := waitBalance(t, accounts, "taker", func(b Balance) bool { return b.Positions["FEE"].Quantity == 10 })
+	if taker.Cash != 10000-2000-5 {
+		t.Fatalf("unexpected taker balance %+v", taker)
+	}
+	waitBalance(t, accounts, "maker", func(b Balance) bool { return b.Cash == 2000+1 })
+
+	for deadline := time.Now().Add(2 * time.Second); len(ledger.Totals("", "", "")) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting on fee totals")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := []FeeTotal{
+		{Account: "maker", Day: "2024-03-01", Fills: 1, Notional: 2000, Rebated: 1, Net: -1},
+		{Account: "taker", Day: "2024-03-01", Fills: 1, Notional: 2000, Paid: 5, Net: 5},
+	}
+	got := ledger.Totals("", "2024-03-01", "2024-03-01")
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected totals %+v, got %+v", want, got)
+	}
+	if got := ledger.Totals("taker", "2024-03-02", ""); len(got) != 0 {
+		t.Fatalf("expected no totals after the day, got %+v", got)
+	}
+}
+
+func TestAccountsReserveFeesForBuyers(t *testing.T) {
+	fees := &FeeSchedule{Tiers: map[string]FeeRates{"retail": {MakerBps: 10, TakerBps: 25}}, DefaultTier: "retail"}
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "FEE", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Unix(0, 0)), Fees: fees}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+	accounts := NewAccounts(ex)
+
+	_ = accounts.DepositPosition("seller", "FEE", 10)
+	_ = accounts.Deposit("buyer", 2000)
+	if err := accounts.SubmitOrder(Order{ID: "s1", Symbol: "FEE", Side: Sell, Type: Limit, Price: 200, Quantity: 10, Owner: "seller"}); err != nil {
+		t.Fatalf("submit s1: %v", err)
+	}
+
+	// Committing every unit of cash to the notional leaves nothing for the fee.
+	err = accounts.SubmitOrder(Order{ID: "b1", Symbol: "FEE", Side: Buy, Type: Limit, Price: 200, Quantity: 10, Owner: "buyer"})
+	if err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	if err := accounts.SubmitOrder(Order{ID: "b2", Symbol: "FEE", Side: Buy, Type: Limit, Price: 200, Quantity: 9, Owner: "buyer"}); err != nil {
+		t.Fatalf("submit b2: %v", err)
+	}
+
+	// 1800 notional at 25bps is a fee of 5 (4.5 rounded); the rest of the
+	// fee reservation comes back with the fill.
+	buyer := waitBalance(t, accounts, "buyer", func(b Balance) bool { return b.Positions["FEE"].Quantity == 9 })
+	if buyer.Cash != 2000-1800-5 || buyer.ReservedCash != 0 {
+		t.Fatalf("unexpected buyer balance %+v", buyer)
+	}
+}
@@ -0,0 +1,147 @@
+package engine
+
+import "time"
+
+// defaultHistory is how many finished orders a book remembers by default.
+const defaultHistory = 10000
+
+// defaultDedupWindow is how long a finished order's ID stays taken by default.
+const defaultDedupWindow = time.Hour
+
+// orderHistory remembers the most recently finished orders, oldest evicted
+// first, so requests for them can say why they no longer work.
+type orderHistory struct {
+	limit  int
+	window time.Duration // how long a finished ID stays taken
+	byID   map[string]closedOrder
+	ring   []string
+	next   int64 // total orders recorded; ring[next%limit] is the next slot
+}
+
+type closedOrder struct {
+	order    Order
+	state    OrderState
+	at       int64 // value of next when recorded
+	finished time.Time
+}
+
+func newOrderHistory(limit int, window time.Duration) orderHistory {
+	if limit <= 0 {
+		limit = defaultHistory
+	}
+	if window == 0 {
+		window = defaultDedupWindow
+	}
+	return orderHistory{limit: limit, window: window, byID: make(map[string]closedOrder)}
+}
+
+// record remembers order as finished in state at now.
+func (h *orderHistory) record(order *Order, state OrderState, now time.Time) {
+	slot := int(h.next % int64(h.limit))
+	if len(h.ring) < h.limit {
+		h.ring = append(h.ring, "")
+	} else if evicted := h.ring[slot]; h.byID[evicted].at == h.next-int64(h.limit) {
+		// Only forget the evicted ID if it was not finished again since.
+		delete(h.byID, evicted)
+	}
+	h.ring[slot] = order.ID
+	h.byID[order.ID] = closedOrder{order: *order, state: state, at: h.next, finished: now}
+	h.next++
+}
+
+// reused reports whether id belongs to an order that finished less than the
+// dedup window before now. A rejected order never entered the book, so its
+// ID can be sent again straight away.
+func (h *orderHistory) reused(id string, now time.Time) bool {
+	closed, ok := h.byID[id]
+	return ok && closed.state != StateRejected && now.Sub(closed.finished) < h.window
+}
+
+// missing explains why id is not working: finished, or never seen.
+func (h *orderHistory) missing(id string) error {
+	closed, ok := h.byID[id]
+	if !ok {
+		return orderError(OrderNotFound, "order %s not found", id)
+	}
+	switch closed.state {
+	case StateFilled:
+		return orderError(OrderFilled, "order %s is already filled", id)
+	case StateExpired:
+		return orderError(OrderClosed, "order %s has expired", id)
+	case StateRejected:
+		return orderError(OrderClosed, "order %s was rejected", id)
+	default:
+		return orderError(OrderClosed, "order %s is already canceled", id)
+	}
+}
+
+// each calls fn for every remembered order, oldest first.
+func (h *orderHistory) each(fn func(closedOrder)) {
+	start := h.next - int64(len(h.ring))
+	for at := start; at < h.next; at++ {
+		if closed := h.byID[h.ring[at%int64(h.limit)]]; closed.at == at {
+			fn(closed)
+		}
+	}
+}
+
+// orderStatuses looks up the order id or, if id is empty, lists the orders
+// of owner in scope.
+func (ob *OrderBook) orderStatuses(id, owner string, scope OrderScope) []OrderStatus {
+	if id != "" {
+		if order, err := ob.workingOrder(id); err == nil {
+			return []OrderStatus{workingStatus(order)}
+		}
+		if closed, ok := ob.history.byID[id]; ok {
+			return []OrderStatus{closed.status()}
+		}
+		return nil
+	}
+
+	var out []OrderStatus
+	owned := func(order *Order) bool { return owner == "" || order.Owner == owner }
+	if scope != ScopeClosed {
+		for _, side := range []*bookSide{&ob.bids, &ob.asks} {
+			side.each(func(entry *orderEntry) bool {
+				if owned(entry.order) {
+					out = append(out, workingStatus(entry.order))
+				}
+				return true
+			})
+		}
+		for _, stops := range [][]*Order{ob.triggers.buys, ob.triggers.sells} {
+			for _, stop := range stops {
+				if owned(stop) {
+					out = append(out, workingStatus(stop))
+				}
+			}
+		}
+	}
+	if scope != ScopeOpen {
+		ob.history.each(func(closed closedOrder) {
+			if owned(&closed.order) {
+				out = append(out, closed.status())
+			}
+		})
+	}
+	return out
+}
+
+func workingStatus(order *Order) OrderStatus {
+	state := StateNew
+	if order.filled > 0 {
+		state = StatePartiallyFilled
+	}
+	return OrderStatus{Order: *order, State: state, CumQty: order.filled, AvgPrice: avgPrice(order), LeavesQty: order.Remaining}
+}
+
+func (c closedOrder) status() OrderStatus {
+	return OrderStatus{Order: c.order, State: c.state, CumQty: c.order.filled, AvgPrice: avgPrice(&c.order)}
+}
+
+func avgPrice(order *Order) float64 {
+	if order.filled == 0 {
+		return 0
+	}
+	return float64(order.notional) / float64(order.filled)
+}
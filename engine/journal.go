@@ -0,0 +1,496 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when journal writes are forced to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every record before the command is acknowledged.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background at most once per SyncInterval,
+	// so a crash can lose up to that much acknowledged history.
+	SyncInterval
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+const defaultSyncInterval = 100 * time.Millisecond
+
+// JournalConfig enables the write-ahead journal for a book. An empty Path
+// keeps the book purely in memory. With SnapshotEvery set, the book writes a
+// snapshot after that many records and drops journal segments it covers.
+type JournalConfig struct {
+	Path          string
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+	SnapshotEvery int
+}
+
+// JournalOp identifies the command stored in a journal record.
+type JournalOp int
+
+const (
+	// JournalAdd records a new order.
+	JournalAdd JournalOp = iota
+	// JournalCancel records a cancel by order ID.
+	JournalCancel
+	// JournalAmend records a price and/or quantity amendment.
+	JournalAmend
+)
+
+// JournalRecord is one accepted command. Sequence is the book sequence the
+// command was applied at and Timestamp the book clock at that moment, so
+// replaying the records through the same code reproduces the book exactly.
+type JournalRecord struct {
+	Index     int64     `json:"index"`
+	Op        JournalOp `json:"op"`
+	Sequence  int64     `json:"seq"`
+	Timestamp time.Time `json:"ts"`
+	Order     *Order    `json:"order,omitempty"`
+	OrderID   string    `json:"orderId,omitempty"`
+	Price     *int64    `json:"price,omitempty"`
+	Quantity  *int64    `json:"quantity,omitempty"`
+}
+
+// Journal is an append-only file of journal records. Each line holds a CRC32
+// of the JSON payload so torn or corrupted writes are detected on recovery.
+// Rotate seals the active file as a numbered segment beside it.
+type Journal struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	w      *bufio.Writer
+	policy SyncPolicy
+	next   int64
+	dirty  bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// OpenJournal opens or creates the journal at cfg.Path and returns the
+// records in its sealed segments and active file, oldest first. A torn final
+// record, left by a crash mid-write, is truncated away; corruption anywhere
+// else is an error.
+func OpenJournal(cfg JournalConfig) (*Journal, []JournalRecord, error) {
+	records, err := readSegments(cfg.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open journal: %w", err)
+	}
+	active, good, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	records = append(records, active...)
+	for i := 1; i < len(records); i++ {
+		if records[i].Index != records[i-1].Index+1 {
+			file.Close()
+			return nil, nil, fmt.Errorf("journal skips from record %d to %d", records[i-1].Index, records[i].Index)
+		}
+	}
+	if err := file.Truncate(good); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("truncate journal: %w", err)
+	}
+	if _, err := file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("seek journal: %w", err)
+	}
+
+	j := &Journal{
+		path:   cfg.Path,
+		file:   file,
+		w:      bufio.NewWriter(file),
+		policy: cfg.Sync,
+		next:   1,
+		done:   make(chan struct{}),
+	}
+	if len(records) > 0 {
+		j.next = records[len(records)-1].Index + 1
+	}
+	if cfg.Sync == SyncInterval {
+		interval := cfg.SyncInterval
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		j.wg.Add(1)
+		go j.syncLoop(interval)
+	}
+	return j, records, nil
+}
+
+// ReadJournal returns every intact record of the journal at path, sealed
+// segments first, without modifying any file. A torn final record is ignored.
+func ReadJournal(path string) ([]JournalRecord, error) {
+	records, err := readSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+	active, _, err := readJournal(file)
+	if err != nil {
+		return nil, err
+	}
+	return append(records, active...), nil
+}
+
+// readSegments reads every sealed segment of the journal at path. Segments
+// were synced before they were sealed, so they must decode completely.
+func readSegments(path string) ([]JournalRecord, error) {
+	segments, err := numberedFiles(path + ".")
+	if err != nil {
+		return nil, err
+	}
+	var records []JournalRecord
+	for _, seg := range segments {
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("open journal segment: %w", err)
+		}
+		recs, good, err := readJournal(file)
+		info, statErr := file.Stat()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if statErr != nil {
+			return nil, fmt.Errorf("stat journal segment: %w", statErr)
+		}
+		if good != info.Size() {
+			return nil, fmt.Errorf("journal segment %s is corrupt", seg.path)
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+type numberedFile struct {
+	path string
+	n    int64
+}
+
+// numberedFiles lists files named prefix followed by a zero-padded number,
+// in ascending order.
+func numberedFiles(prefix string) ([]numberedFile, error) {
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	var out []numberedFile
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, prefix)
+		if len(suffix) != 20 {
+			continue
+		}
+		n, err := strconv.ParseInt(suffix, 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, numberedFile{path: match, n: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].n < out[j].n })
+	return out, nil
+}
+
+func numberedName(prefix string, n int64) string {
+	return fmt.Sprintf("%s%020d", prefix, n)
+}
+
+// readJournal decodes every intact record and returns the offset just past
+// the last one.
+func readJournal(r io.Reader) ([]JournalRecord, int64, error) {
+	var (
+		records []JournalRecord
+		offset  int64
+	)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline is a torn write.
+			return records, offset, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("read journal: %w", err)
+		}
+
+		rec, ok := decodeRecord(line)
+		if !ok {
+			if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+				return records, offset, nil
+			}
+			return nil, 0, fmt.Errorf("journal corrupt after record %d", len(records))
+		}
+		records = append(records, rec)
+		offset += int64(len(line))
+	}
+}
+
+func decodeRecord(line []byte) (JournalRecord, bool) {
+	var rec JournalRecord
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, payload, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return rec, false
+	}
+	var want uint32
+	if _, err := fmt.Sscanf(string(sum), "%08x", &want); err != nil || crc32.ChecksumIEEE(payload) != want {
+		return rec, false
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+// Append assigns the record its index and writes it according to the sync
+// policy. The record is durable (for SyncAlways) once Append returns.
+func (j *Journal) Append(rec *JournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec.Index = j.next
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode journal record: %w", err)
+	}
+	if _, err := fmt.Fprintf(j.w, "%08x %s\n", crc32.ChecksumIEEE(payload), payload); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if j.policy == SyncAlways {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("sync journal: %w", err)
+		}
+	} else {
+		j.dirty = true
+	}
+	j.next++
+	return nil
+}
+
+// Rotate seals the active file as a segment named after its last record and
+// starts an empty one. It returns that last index. An empty active file is
+// left in place.
+func (j *Journal) Rotate() (int64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	last := j.next - 1
+	if err := j.w.Flush(); err != nil {
+		return 0, fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("sync journal: %w", err)
+	}
+	if size, err := j.file.Seek(0, io.SeekCurrent); err != nil || size == 0 {
+		return last, err
+	}
+	if err := os.Rename(j.path, numberedName(j.path+".", last)); err != nil {
+		return 0, fmt.Errorf("seal journal segment: %w", err)
+	}
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("open journal: %w", err)
+	}
+	j.file.Close()
+	j.file = file
+	j.w.Reset(file)
+	j.dirty = false
+	return last, nil
+}
+
+// Prune deletes sealed segments whose records all have an index at or below
+// upTo.
+func (j *Journal) Prune(upTo int64) error {
+	segments, err := numberedFiles(j.path + ".")
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg.n > upTo {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return fmt.Errorf("remove journal segment: %w", err)
+		}
+	}
+	return nil
+}
+
+func (j *Journal) syncLoop(interval time.Duration) {
+	defer j.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty {
+				_ = j.file.Sync()
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// Close flushes and syncs outstanding records and closes the file.
+func (j *Journal) Close() error {
+	close(j.done)
+	j.wg.Wait()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		j.file.Close()
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// journalAppend writes a command to the journal before it is applied. Books
+// without a journal, including one still replaying its history, skip it.
+func (ob *OrderBook) journalAppend(rec JournalRecord) error {
+	if ob.journal == nil {
+		return nil
+	}
+	if err := ob.journal.Append(&rec); err != nil {
+		return err
+	}
+	ob.sinceSnapshot++
+	return nil
+}
+
+// recover restores the newest valid snapshot, replays the journal records
+// after it, and attaches the journal for new commands.
+func (ob *OrderBook) recover() error {
+	snap, err := loadSnapshot(ob.cfg.Journal.Path)
+	if err != nil {
+		return err
+	}
+	journal, records, err := OpenJournal(ob.cfg.Journal)
+	if err != nil {
+		return err
+	}
+
+	var covered int64
+	if snap != nil {
+		if err := ob.restore(snap); err != nil {
+			journal.Close()
+			return err
+		}
+		covered = snap.index
+	}
+	for len(records) > 0 && records[0].Index <= covered {
+		records = records[1:]
+	}
+	if len(records) > 0 && records[0].Index != covered+1 {
+		journal.Close()
+		return fmt.Errorf("journal resumes at record %d but the book only covers up to %d", records[0].Index, covered)
+	}
+	if err := ob.replay(records); err != nil {
+		journal.Close()
+		return err
+	}
+	if journal.next <= covered {
+		journal.next = covered + 1
+	}
+	ob.journal = journal
+	return nil
+}
+
+// replay rebuilds the book by feeding journal records through the normal
+// processing paths. The clock is pinned to each record's timestamp and no
+// trades, reports or market data are published.
+func (ob *OrderBook) replay(records []JournalRecord) error {
+	now := ob.now
+	ob.replaying = true
+	defer func() {
+		ob.now = now
+		ob.replaying = false
+	}()
+
+	for _, rec := range records {
+		at := rec.Timestamp
+		ob.now = func() time.Time { return at }
+		if err := ob.apply(rec); err != nil {
+			return fmt.Errorf("replay journal record %d: %w", rec.Index, err)
+		}
+	}
+	ob.bids.flush()
+	ob.asks.flush()
+	return nil
+}
+
+// apply re-runs one journaled command. Adds may fail again exactly as they did
+// live (a post-only order that would take, say); a failed cancel or amend, or
+// a sequence mismatch, means the book has diverged from the journal.
+func (ob *OrderBook) apply(rec JournalRecord) error {
+	switch rec.Op {
+	case JournalAdd:
+		if rec.Order == nil {
+			return errors.New("add record has no order")
+		}
+		if err := ob.expectSequence(rec.Sequence - 1); err != nil {
+			return err
+		}
+		_ = ob.processAdd(*rec.Order)
+		return nil
+	case JournalCancel:
+		if err := ob.expectSequence(rec.Sequence); err != nil {
+			return err
+		}
+		return ob.processCancel(rec.OrderID)
+	case JournalAmend:
+		if err := ob.expectSequence(rec.Sequence - 1); err != nil {
+			return err
+		}
+		return ob.processAmend(rec.OrderID, rec.Price, rec.Quantity)
+	default:
+		return fmt.Errorf("unknown journal op %d", rec.Op)
+	}
+}
+
+func (ob *OrderBook) expectSequence(seq int64) error {
+	if ob.seq != seq {
+		return fmt.Errorf("book at sequence %d, journal expects %d", ob.seq, seq)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalRecoversRestingOrders(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	cfg := OrderBookConfig{
+		Symbol:   "BTCUSD",
+		TickSize: 1,
+		MaxDepth: 10,
+		Inline:   true,
+		Journal:  JournalConfig{Path: filepath.Join(t.TempDir(), "BTCUSD.journal")},
+		Clock:    clock,
+	}
+
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+	orders := []Order{
+		{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 101, Quantity: 5},
+		{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 103, Quantity: 8, DisplayQuantity: 2},
+		{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 99, Quantity: 4},
+		{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 101, Quantity: 3},
+		{ID: "stop1", Symbol: "BTCUSD", Side: Buy, Type: Stop, StopPrice: 110, Quantity: 1},
+		{ID: "gtd1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 98, Quantity: 1, TimeInForce: GTD, ExpireAt: time.Unix(5, 0)},
+	}
+	for _, order := range orders {
+		if err := ob.SubmitOrder(order); err != nil {
+			t.Fatalf("submit %s: %v", order.ID, err)
+		}
+	}
+	if err := ob.SubmitOrder(Order{ID: "po", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 103, Quantity: 1, PostOnly: PostOnlyReject}); err == nil {
+		t.Fatalf("post-only order should be rejected")
+	}
+	price := int64(97)
+	if err := ob.AmendOrder("bid1", &price, nil); err != nil {
+		t.Fatalf("amend: %v", err)
+	}
+	if err := ob.CancelOrder("ask2"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	before, _ := ob.L3Snapshot()
+	stopsBefore, _ := ob.StopOrders()
+	seq := ob.seq
+	ob.Stop()
+
+	recovered, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("recover book: %v", err)
+	}
+	defer recovered.Stop()
+
+	if recovered.seq != seq {
+		t.Fatalf("expected sequence %d after recovery, got %d", seq, recovered.seq)
+	}
+	after, _ := recovered.L3Snapshot()
+	if len(after.Bids) != len(before.Bids) || len(after.Asks) != len(before.Asks) || after.Sequence != before.Sequence {
+		t.Fatalf("expected book %+v, got %+v", before, after)
+	}
+	for i := range before.Bids {
+		if after.Bids[i].ID != before.Bids[i].ID || after.Bids[i].Price != before.Bids[i].Price || after.Bids[i].Remaining != before.Bids[i].Remaining {
+			t.Fatalf("bid %d: expected %+v, got %+v", i, before.Bids[i], after.Bids[i])
+		}
+	}
+	stopsAfter, _ := recovered.StopOrders()
+	if len(stopsAfter) != len(stopsBefore) || stopsAfter[0].ID != "stop1" {
+		t.Fatalf("expected stops %+v, got %+v", stopsBefore, stopsAfter)
+	}
+	select {
+	case trade := <-recovered.Trades():
+		t.Fatalf("replay should not republish trades, got %+v", trade)
+	default:
+	}
+
+	clock.Set(time.Unix(1, 0))
+	if err := recovered.SubmitOrder(Order{ID: "ask3", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 102, Quantity: 1}); err != nil {
+		t.Fatalf("submit after recovery: %v", err)
+	}
+	if entry := recovered.orders["ask3"]; entry == nil || entry.order.Sequence != seq+1 {
+		t.Fatalf("expected new order at sequence %d", seq+1)
+	}
+}
+
+func TestJournalDropsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ETHUSD.journal")
+	cfg := OrderBookConfig{Symbol: "ETHUSD", TickSize: 1, MaxDepth: 10, Inline: true, Journal: JournalConfig{Path: path}}
+
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+	if err := ob.SubmitOrder(Order{ID: "bid1", Symbol: "ETHUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	ob.Stop()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	file.WriteString(`1234abcd {"index":2,"op":0`)
+	file.Close()
+
+	recovered, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("recover with torn tail: %v", err)
+	}
+	defer recovered.Stop()
+	if _, ok := recovered.orders["bid1"]; !ok {
+		t.Fatalf("expected bid1 to survive recovery")
+	}
+	if err := recovered.SubmitOrder(Order{ID: "bid2", Symbol: "ETHUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1}); err != nil {
+		t.Fatalf("submit after recovery: %v", err)
+	}
+	_, records, err := OpenJournal(JournalConfig{Path: path, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("reread journal: %v", err)
+	}
+	if len(records) != 2 || records[1].Index != 2 || records[1].Order.ID != "bid2" {
+		t.Fatalf("expected torn record replaced by bid2, got %+v", records)
+	}
+}
+
+// TestReplayOfLargeSweepDeliversEveryFill replays a journal the way
+// cmd/replay does: one record fills more orders than the trade buffer holds,
+// so the trades are read while it is applied.
+func TestReplayOfLargeSweepDeliversEveryFill(t *testing.T) {
+	const fills = 1500
+	path := filepath.Join(t.TempDir(), "SWEEP.journal")
+	ob, err := OpenOrderBook(OrderBookConfig{Symbol: "SWEEP", TickSize: 1, MaxDepth: fills, Inline: true,
+		Journal: JournalConfig{Path: path}, Clock: NewSimClock(time.Unix(0, 0)), TradePolicy: OutputDrop})
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+	for i := 0; i < fills; i++ {
+		if err := ob.SubmitOrder(Order{ID: fmt.Sprintf("ask%d", i), Symbol: "SWEEP", Side: Sell, Type: Limit, Price: 100, Quantity: 1}); err != nil {
+			t.Fatalf("submit ask%d: %v", i, err)
+		}
+	}
+	if err := ob.SubmitOrder(Order{ID: "sweep", Symbol: "SWEEP", Side: Buy, Type: Limit, Price: 100, Quantity: fills}); err != nil {
+		t.Fatalf("submit sweep: %v", err)
+	}
+	ob.Stop()
+
+	records, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	replay := NewOrderBook(OrderBookConfig{Symbol: "SWEEP", TickSize: 1, MaxDepth: fills, Inline: true, Clock: NewSimClock(time.Unix(0, 0))})
+	defer replay.Stop()
+	trades := replay.SubscribeTrades(SubscribeOptions{Policy: OutputBlock}).C
+	for _, rec := range records[:len(records)-1] {
+		if err := replay.SubmitOrder(*rec.Order); err != nil {
+			t.Fatalf("replay record %d: %v", rec.Index, err)
+		}
+	}
+
+	last := records[len(records)-1]
+	done := make(chan error, 1)
+	go func() { done <- replay.SubmitOrder(*last.Order) }()
+	n := 0
+	for applied := false; !applied; {
+		select {
+		case <-trades:
+			n++
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("replay sweep: %v", err)
+			}
+			applied = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("replay of the sweep stalled after %d trades", n)
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case <-trades:
+			n++
+		default:
+			drained = true
+		}
+	}
+	if n != fills {
+		t.Fatalf("expected %d trades from the sweep, got %d", fills, n)
+	}
+}
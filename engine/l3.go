@@ -0,0 +1,43 @@
+package engine
+
+// emitL3 publishes a market-by-order event for a resting entry. Events are
+// dropped rather than stalling the book; consumers detect gaps by sequence.
+func (ob *OrderBook) emitL3(typ L3EventType, entry *orderEntry, qty, price int64) {
+	// Counted during replay too, so sequences, and the trades that carry
+	// them, carry on where they were.
+	ob.l3Seq++
+	if ob.replaying {
+		return
+	}
+	event := L3Event{
+		Sequence:  ob.l3Seq,
+		Type:      typ,
+		OrderID:   entry.order.ID,
+		Symbol:    entry.order.Symbol,
+		Side:      entry.order.Side,
+		Price:     price,
+		Quantity:  qty,
+		Timestamp: ob.now(),
+	}
+	ob.l3Feed.publish(event)
+}
+
+func (ob *OrderBook) l3View() L3Snapshot {
+	return L3Snapshot{
+		Symbol:   ob.cfg.Symbol,
+		Sequence: ob.l3Seq,
+		Bids:     sortedOrders(&ob.bids),
+		Asks:     sortedOrders(&ob.asks),
+	}
+}
+
+// sortedOrders copies a side of the book in priority order, showing only the
+// visible slice of iceberg orders.
+func sortedOrders(side *bookSide) []Order {
+	out := make([]Order, 0, side.Len())
+	side.each(func(entry *orderEntry) bool {
+		out = append(out, *publicCopy(entry))
+		return true
+	})
+	return out
+}
@@ -4,7 +4,9 @@ import (
 	"container/heap"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,8 +16,16 @@ const (
 	requestAdd requestType = iota
 	requestCancel
 	requestAmend
+	requestReplace
+	requestBatch
+	requestMassCancel
 	requestSnapshot
+	requestStops
+	requestDepth
+	requestL3
+	requestOrders
 	requestStop
+	requestNone
 )
 
 type bookRequest struct {
@@ -23,55 +33,130 @@ type bookRequest struct {
 	order      Order
 	amendPrice *int64
 	amendQty   *int64
+	newID      string
 	resp       chan error
 	view       chan BookView
+	stops      chan []Order
+	levels     int
+	depth      chan Depth
+	l3         chan L3Snapshot
+	owner      string
+	scope      OrderScope
+	statuses   chan []OrderStatus
+	batch      []Order
+	errs       chan []error
+	filter     CancelFilter
+	canceled   chan []Order
 }
 
 // OrderBook maintains bids and asks for a single symbol using price-time priority.
 type OrderBook struct {
 	cfg        OrderBookConfig
-	bids       priceTimeQueue
-	asks       priceTimeQueue
+	bids       bookSide
+	asks       bookSide
 	orders     map[string]*orderEntry
+	triggers   triggerBook
+	expiries   expiryQueue
+	history    orderHistory
+	lastPrice  int64
 	seq        int64
+	tradeSeq   int64
+	depthSeq   int64
+	l3Seq      int64
 	reqCh      chan bookRequest
-	trades     chan MatchResult
-	updates    chan BookView
+	tradeFeed  *fanout[MatchResult]
+	updateFeed *fanout[BookView]
+	reportFeed *fanout[ExecutionReport]
+	depthFeed  *fanout[DepthUpdate]
+	l3Feed     *fanout[L3Event]
+	trades     *Subscription[MatchResult]
+	updates    <-chan BookView
+	reports    <-chan ExecutionReport
+	depth      <-chan DepthUpdate
+	l3         <-chan L3Event
+	clock      Clock
 	now        func() time.Time
+	expiryTick bool
+	journal    *Journal
+	replaying  bool
 	entryPool  sync.Pool
 	errChPool  sync.Pool
 	viewChPool sync.Pool
 	inline     bool
 	closeOnce  sync.Once
+
+	sinceSnapshot int
+	snapshotBusy  atomic.Bool
+	snapshotWG    sync.WaitGroup
 }
 
-// NewOrderBook builds an order book and launches the worker loop.
+// NewOrderBook builds an order book and launches the worker loop. It panics if
+// a configured journal cannot be recovered; use OpenOrderBook to handle that.
 func NewOrderBook(cfg OrderBookConfig) *OrderBook {
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return ob
+}
+
+// OpenOrderBook builds an order book, rebuilds its state from the latest
+// snapshot and the configured journal, and launches the worker loop.
+func OpenOrderBook(cfg OrderBookConfig) (*OrderBook, error) {
+	ob := newOrderBook(cfg)
+	if cfg.Journal.Path != "" {
+		if err := ob.recover(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !ob.inline {
+		ob.reqCh = make(chan bookRequest, ob.cfg.RequestBuffer)
+		go ob.run()
+	}
+	return ob, nil
+}
+
+func newOrderBook(cfg OrderBookConfig) *OrderBook {
 	if cfg.RequestBuffer < 0 {
 		cfg.RequestBuffer = 0
 	}
 
 	ob := &OrderBook{
 		cfg:        cfg,
-		bids:       priceTimeQueue{},
-		asks:       priceTimeQueue{},
+		bids:       newBookSide(true),
+		asks:       newBookSide(false),
 		orders:     make(map[string]*orderEntry),
-		trades:     make(chan MatchResult, 1024),
-		updates:    make(chan BookView, 16),
-		now:        time.Now,
+		triggers:   newTriggerBook(),
+		history:    newOrderHistory(cfg.History, cfg.DedupWindow),
+		tradeFeed:  newFanout[MatchResult](1024, cfg.TradePolicy),
+		updateFeed: newFanout[BookView](16, OutputDrop),
+		reportFeed: newFanout[ExecutionReport](1024, OutputDrop),
+		depthFeed:  newFanout[DepthUpdate](256, OutputDrop),
+		l3Feed:     newFanout[L3Event](4096, OutputDrop),
 		inline:     cfg.Inline,
 		entryPool:  sync.Pool{New: func() any { return &orderEntry{} }},
 		errChPool:  sync.Pool{New: func() any { return make(chan error, 1) }},
 		viewChPool: sync.Pool{New: func() any { return make(chan BookView, 1) }},
 	}
 
-	heap.Init(&ob.bids)
-	heap.Init(&ob.asks)
+	ob.clock = cfg.Clock
+	if ob.clock == nil {
+		ob.clock = systemClock{}
+		ob.expiryTick = true
+	}
+	ob.now = ob.clock.Now
 
-	if !cfg.Inline {
-		ob.reqCh = make(chan bookRequest, cfg.RequestBuffer)
-		go ob.run()
+	// Nothing has to read Trades, so its stream never stalls matching.
+	tradePolicy := cfg.TradePolicy
+	if tradePolicy == OutputBlock {
+		tradePolicy = OutputDrop
 	}
+	ob.trades = ob.tradeFeed.subscribeWith(0, tradePolicy, nil)
+	ob.updates = ob.updateFeed.subscribe()
+	ob.reports = ob.reportFeed.subscribe()
+	ob.depth = ob.depthFeed.subscribe()
+	ob.l3 = ob.l3Feed.subscribe()
 
 	return ob
 }
@@ -102,6 +187,7 @@ func (ob *OrderBook) newEntry(order *Order) *orderEntry {
 	entry := ob.entryPool.Get().(*orderEntry)
 	entry.order = order
 	entry.isBid = order.Side == Buy
+	entry.visible = displaySlice(order)
 	return entry
 }
 
@@ -109,20 +195,40 @@ func (ob *OrderBook) releaseEntry(entry *orderEntry) {
 	if entry == nil {
 		return
 	}
-	entry.order = nil
-	entry.index = 0
-	entry.isBid = false
+	*entry = orderEntry{}
 	ob.entryPool.Put(entry)
 }
 
 func (ob *OrderBook) closeChannels() {
-	close(ob.trades)
-	close(ob.updates)
+	ob.snapshotWG.Wait()
+	if ob.journal != nil {
+		ob.journal.Close()
+	}
+	ob.tradeFeed.close()
+	ob.updateFeed.close()
+	ob.reportFeed.close()
+	ob.depthFeed.close()
+	ob.l3Feed.close()
 	if ob.reqCh != nil {
 		close(ob.reqCh)
 	}
 }
 
+// Clock returns the clock the book runs on.
+func (ob *OrderBook) Clock() Clock {
+	return ob.clock
+}
+
+// Symbol returns the symbol traded on this book.
+func (ob *OrderBook) Symbol() string {
+	return ob.cfg.Symbol
+}
+
+// DedupWindow returns how long the ID of a finished order stays taken.
+func (ob *OrderBook) DedupWindow() time.Duration {
+	return ob.history.window
+}
+
 // SubmitOrder enqueues a new order for processing.
 func (ob *OrderBook) SubmitOrder(order Order) error {
 	if ob.inline {
@@ -130,6 +236,7 @@ func (ob *OrderBook) SubmitOrder(order Order) error {
 		if err == nil {
 			ob.publishView()
 		}
+		ob.maybeSnapshot()
 		return err
 	}
 
@@ -147,6 +254,7 @@ func (ob *OrderBook) CancelOrder(id string) error {
 		if err == nil {
 			ob.publishView()
 		}
+		ob.maybeSnapshot()
 		return err
 	}
 
@@ -158,12 +266,15 @@ func (ob *OrderBook) CancelOrder(id string) error {
 }
 
 // AmendOrder updates price and/or quantity for an existing resting order.
+// The quantity is the new total, fills included. An amend never trades, so a
+// price that would cross the book is refused with OrderWouldCross.
 func (ob *OrderBook) AmendOrder(id string, price *int64, qty *int64) error {
 	if ob.inline {
 		err := ob.processAmend(id, price, qty)
 		if err == nil {
 			ob.publishView()
 		}
+		ob.maybeSnapshot()
 		return err
 	}
 
@@ -174,9 +285,73 @@ func (ob *OrderBook) AmendOrder(id string, price *int64, qty *int64) error {
 	return err
 }
 
+// ReplaceOrder atomically cancels a working order and enters a new one under
+// newID in its place. The replacement copies the original, taking price and
+// quantity from the arguments when given; without a quantity it gets the
+// original's remaining quantity. Unlike an amend, the fills of the original
+// stay with it and the replacement starts unfilled. A replacement that fails
+// validation leaves the original working.
+func (ob *OrderBook) ReplaceOrder(id, newID string, price *int64, qty *int64) error {
+	if ob.inline {
+		err := ob.processReplace(id, newID, price, qty)
+		if err == nil {
+			ob.publishView()
+		}
+		ob.maybeSnapshot()
+		return err
+	}
+
+	resp := ob.getErrCh()
+	ob.reqCh <- bookRequest{typ: requestReplace, order: Order{ID: id}, newID: newID, amendPrice: price, amendQty: qty, resp: resp}
+	err := <-resp
+	ob.putErrCh(resp)
+	return err
+}
+
+// SubmitOrders processes a batch of new orders as one request, in order, and
+// returns an error, or nil, per order. Market data is published once, after
+// the whole batch.
+func (ob *OrderBook) SubmitOrders(orders []Order) []error {
+	if ob.inline {
+		errs, changed := ob.processBatch(orders)
+		if changed {
+			ob.publishView()
+		}
+		ob.maybeSnapshot()
+		return errs
+	}
+
+	errs := make(chan []error, 1)
+	ob.reqCh <- bookRequest{typ: requestBatch, batch: orders, errs: errs}
+	return <-errs
+}
+
+// CancelOrders cancels every working order that matches filter, pending
+// stops included, as one request and returns copies of the orders it
+// canceled. Market data is published once, after the last cancel.
+func (ob *OrderBook) CancelOrders(filter CancelFilter) ([]Order, error) {
+	if ob.inline {
+		canceled, err := ob.processMassCancel(filter)
+		if len(canceled) > 0 {
+			ob.publishView()
+		}
+		ob.maybeSnapshot()
+		return canceled, err
+	}
+
+	resp := ob.getErrCh()
+	canceledCh := make(chan []Order, 1)
+	ob.reqCh <- bookRequest{typ: requestMassCancel, filter: filter, canceled: canceledCh, resp: resp}
+	canceled := <-canceledCh
+	err := <-resp
+	ob.putErrCh(resp)
+	return canceled, err
+}
+
 // Snapshot returns a view of the best bid and ask for the book.
 func (ob *OrderBook) Snapshot() (BookView, error) {
 	if ob.inline {
+		ob.expireOrders(ob.now())
 		return ob.snapshotView(), nil
 	}
 
@@ -190,9 +365,132 @@ func (ob *OrderBook) Snapshot() (BookView, error) {
 	return viewResp, err
 }
 
-// Trades exposes the stream of executed trades.
+// StopOrders returns copies of the stop orders waiting in the trigger book,
+// buy stops first, each side in the order they would trigger.
+func (ob *OrderBook) StopOrders() ([]Order, error) {
+	if ob.inline {
+		ob.expireOrders(ob.now())
+		return ob.triggers.list(), nil
+	}
+
+	stops := make(chan []Order, 1)
+	ob.reqCh <- bookRequest{typ: requestStops, stops: stops}
+	return <-stops, nil
+}
+
+// Depth returns aggregated price levels, best first, for up to n levels per
+// side (n <= 0 returns every level). Apply DepthUpdates with a greater
+// Sequence to keep the ladder current.
+func (ob *OrderBook) Depth(n int) (Depth, error) {
+	if ob.inline {
+		ob.expireOrders(ob.now())
+		return ob.depthView(n), nil
+	}
+
+	depth := make(chan Depth, 1)
+	ob.reqCh <- bookRequest{typ: requestDepth, levels: n, depth: depth}
+	return <-depth, nil
+}
+
+// L3Snapshot returns every visible resting order in priority order. Apply
+// L3Events with a greater Sequence to keep a local copy of the book.
+func (ob *OrderBook) L3Snapshot() (L3Snapshot, error) {
+	if ob.inline {
+		ob.expireOrders(ob.now())
+		return ob.l3View(), nil
+	}
+
+	snapshot := make(chan L3Snapshot, 1)
+	ob.reqCh <- bookRequest{typ: requestL3, l3: snapshot}
+	return <-snapshot, nil
+}
+
+// OrderStatus reports where an order stands, whether it is working or one of
+// the finished orders the book remembers (see OrderBookConfig.History).
+func (ob *OrderBook) OrderStatus(id string) (OrderStatus, error) {
+	statuses := ob.queryOrders(id, "", ScopeAll)
+	if len(statuses) == 0 {
+		return OrderStatus{}, orderError(OrderNotFound, "order %s not found", id)
+	}
+	return statuses[0], nil
+}
+
+// Orders lists the orders of owner, or everyone's if owner is empty. Open
+// orders come first, resting bids then asks in priority order and then
+// pending stops; closed orders follow in the order they finished.
+func (ob *OrderBook) Orders(owner string, scope OrderScope) ([]OrderStatus, error) {
+	return ob.queryOrders("", owner, scope), nil
+}
+
+func (ob *OrderBook) queryOrders(id, owner string, scope OrderScope) []OrderStatus {
+	if ob.inline {
+		ob.expireOrders(ob.now())
+		return ob.orderStatuses(id, owner, scope)
+	}
+
+	statuses := make(chan []OrderStatus, 1)
+	ob.reqCh <- bookRequest{typ: requestOrders, order: Order{ID: id}, owner: owner, scope: scope, statuses: statuses}
+	return <-statuses
+}
+
+// Trades exposes the stream of executed trades. When the consumer falls
+// behind, the book follows OrderBookConfig.TradePolicy, except that it drops
+// rather than blocks: the stream exists whether or not anyone reads it. Use
+// SubscribeTrades with OutputBlock for backpressure. A book opened by an
+// Exchange hands its trades to Exchange.Trades, and this stream is closed.
 func (ob *OrderBook) Trades() <-chan MatchResult {
-	return ob.trades
+	return ob.trades.C
+}
+
+// SubscribeTrades returns a new stream that receives every trade from now on,
+// independently of Trades and other subscribers.
+func (ob *OrderBook) SubscribeTrades(opts SubscribeOptions) *Subscription[MatchResult] {
+	var filter func(MatchResult) bool
+	if owns := opts.OrderIDs; owns != nil {
+		filter = func(m MatchResult) bool { return owns(m.BuyOrderID) || owns(m.SellOrderID) }
+	}
+	return ob.tradeFeed.subscribeWith(opts.Buffer, opts.Policy, filter)
+}
+
+// SubscribeBookUpdates returns a new stream of top-of-book views, independent
+// of BookUpdates and other subscribers. Unread views are dropped.
+func (ob *OrderBook) SubscribeBookUpdates(opts SubscribeOptions) *Subscription[BookView] {
+	var filter func(BookView) bool
+	if owns := opts.OrderIDs; owns != nil {
+		filter = func(v BookView) bool {
+			return (v.BestBid != nil && owns(v.BestBid.ID)) || (v.BestAsk != nil && owns(v.BestAsk.ID))
+		}
+	}
+	return ob.updateFeed.subscribeWith(opts.Buffer, OutputDrop, filter)
+}
+
+// SubscribeExecutionReports returns a new stream of execution reports,
+// independent of ExecutionReports and other subscribers. Unlike the default
+// stream it follows opts.Policy, so a consumer that must see every report can
+// ask for OutputSpill.
+func (ob *OrderBook) SubscribeExecutionReports(opts SubscribeOptions) *Subscription[ExecutionReport] {
+	var filter func(ExecutionReport) bool
+	switch owns, owner := opts.OrderIDs, opts.Owner; {
+	case owns != nil && owner != "":
+		filter = func(r ExecutionReport) bool { return r.Owner == owner && owns(r.OrderID) }
+	case owns != nil:
+		filter = func(r ExecutionReport) bool { return owns(r.OrderID) }
+	case owner != "":
+		filter = func(r ExecutionReport) bool { return r.Owner == owner }
+	}
+	return ob.reportFeed.subscribeWith(opts.Buffer, opts.Policy, filter)
+}
+
+// OutputStats reports how many events each stream has dropped. It is safe to
+// call from any goroutine.
+func (ob *OrderBook) OutputStats() OutputStats {
+	return OutputStats{
+		Trades:  ob.tradeFeed.dropped.Load(),
+		Updates: ob.updateFeed.dropped.Load(),
+		Reports: ob.reportFeed.dropped.Load(),
+		Depth:   ob.depthFeed.dropped.Load(),
+		L3:      ob.l3Feed.dropped.Load(),
+	}
 }
 
 // BookUpdates exposes the stream of top-of-book updates.
@@ -200,6 +498,24 @@ func (ob *OrderBook) BookUpdates() <-chan BookView {
 	return ob.updates
 }
 
+// ExecutionReports exposes the stream of order lifecycle events. Reports are
+// dropped rather than stalling the book if the consumer falls behind.
+func (ob *OrderBook) ExecutionReports() <-chan ExecutionReport {
+	return ob.reports
+}
+
+// DepthUpdates exposes incremental price level changes. Updates are dropped
+// rather than stalling the book; on a sequence gap, take a fresh Depth.
+func (ob *OrderBook) DepthUpdates() <-chan DepthUpdate {
+	return ob.depth
+}
+
+// L3Events exposes the market-by-order feed. Events are dropped rather than
+// stalling the book; on a sequence gap, take a fresh L3Snapshot.
+func (ob *OrderBook) L3Events() <-chan L3Event {
+	return ob.l3
+}
+
 // Stop gracefully terminates the worker loop.
 func (ob *OrderBook) Stop() {
 	ob.closeOnce.Do(func() {
@@ -212,7 +528,41 @@ func (ob *OrderBook) Stop() {
 }
 
 func (ob *OrderBook) run() {
-	for req := range ob.reqCh {
+	var (
+		timer   *time.Timer
+		expiryC <-chan time.Time
+		armed   time.Time
+	)
+	for {
+		ob.maybeSnapshot()
+
+		// Re-arm the expiry timer whenever the earliest deadline changes.
+		if next := ob.nextExpiry(); ob.expiryTick && !next.Equal(armed) {
+			if timer != nil {
+				timer.Stop()
+			}
+			armed, expiryC = next, nil
+			if !next.IsZero() {
+				timer = time.NewTimer(next.Sub(ob.now()))
+				expiryC = timer.C
+			}
+		}
+
+		var req bookRequest
+		select {
+		case r, ok := <-ob.reqCh:
+			if !ok {
+				return
+			}
+			req = r
+		case <-expiryC:
+			if ob.expireOrders(ob.now()) {
+				ob.publishView()
+			}
+			armed, expiryC = time.Time{}, nil
+			req.typ = requestNone
+		}
+
 		switch req.typ {
 		case requestAdd:
 			err := ob.processAdd(req.order)
@@ -232,9 +582,43 @@ func (ob *OrderBook) run() {
 			if err == nil {
 				ob.publishView()
 			}
+		case requestReplace:
+			err := ob.processReplace(req.order.ID, req.newID, req.amendPrice, req.amendQty)
+			req.resp <- err
+			if err == nil {
+				ob.publishView()
+			}
+		case requestBatch:
+			errs, changed := ob.processBatch(req.batch)
+			req.errs <- errs
+			if changed {
+				ob.publishView()
+			}
+		case requestMassCancel:
+			canceled, err := ob.processMassCancel(req.filter)
+			req.canceled <- canceled
+			req.resp <- err
+			if len(canceled) > 0 {
+				ob.publishView()
+			}
 		case requestSnapshot:
 			ob.handleSnapshot(req.view, req.resp)
+		case requestStops:
+			ob.expireOrders(ob.now())
+			req.stops <- ob.triggers.list()
+		case requestDepth:
+			ob.expireOrders(ob.now())
+			req.depth <- ob.depthView(req.levels)
+		case requestL3:
+			ob.expireOrders(ob.now())
+			req.l3 <- ob.l3View()
+		case requestOrders:
+			ob.expireOrders(ob.now())
+			req.statuses <- ob.orderStatuses(req.order.ID, req.owner, req.scope)
 		case requestStop:
+			if timer != nil {
+				timer.Stop()
+			}
 			ob.closeChannels()
 			return
 		}
@@ -242,85 +626,478 @@ func (ob *OrderBook) run() {
 }
 
 func (ob *OrderBook) processAdd(order Order) error {
+	now := ob.now()
+	ob.expireOrders(now)
+
+	// A duplicate is turned away without a report, which consumers would
+	// take for news of the order already holding the ID.
+	if err := ob.checkNewID(order.ID, now); err != nil {
+		return err
+	}
+	if err := ob.admit(&order, now); err != nil {
+		ob.report(&order, StateRejected, 0, 0, err.Error())
+		return err
+	}
+	return nil
+}
+
+// admit validates a new order, journals it, and either parks it in the
+// trigger book or executes it against the book.
+func (ob *OrderBook) admit(order *Order, now time.Time) error {
+	if err := ob.validate(order, now); err != nil {
+		return err
+	}
+
+	accepted := *order
+	if err := ob.journalAppend(JournalRecord{Op: JournalAdd, Sequence: ob.seq + 1, Timestamp: now, Order: &accepted}); err != nil {
+		return err
+	}
+
+	if order.Type == Stop || order.Type == StopLimit {
+		ob.seq++
+		order.Sequence = ob.seq
+		order.Timestamp = now
+		stop := *order
+		ob.triggers.add(&stop)
+		if !stop.ExpireAt.IsZero() {
+			heap.Push(&ob.expiries, expiryItem{at: stop.ExpireAt, id: stop.ID})
+		}
+		ob.report(&stop, StateNew, 0, 0, "")
+		return nil
+	}
+
+	if err := ob.execute(order, now, false); err != nil {
+		return err
+	}
+	ob.fireTriggers()
+	return nil
+}
+
+// checkNewID rejects an ID that a working order holds or that a finished one
+// held within the dedup window.
+func (ob *OrderBook) checkNewID(id string, now time.Time) error {
+	if id == "" {
+		return nil
+	}
+	if _, err := ob.workingOrder(id); err == nil {
+		return orderError(OrderDuplicateID, "order %s is already working", id)
+	}
+	if ob.history.reused(id, now) {
+		return orderError(OrderDuplicateID, "order id %s was used recently", id)
+	}
+	return nil
+}
+
+// validate checks a new order against the book's rules and fills in its
+// expiry and remaining quantity.
+func (ob *OrderBook) validate(order *Order, now time.Time) error {
 	if order.Symbol != ob.cfg.Symbol {
 		return fmt.Errorf("order symbol %s does not match book %s", order.Symbol, ob.cfg.Symbol)
 	}
 	if order.Quantity <= 0 {
-		return errors.New("order quantity must be positive")
+		return orderError(OrderBadQuantity, "order quantity must be positive")
 	}
-	if order.Type == Limit {
+	if order.Type == Limit || order.Type == StopLimit {
 		if ob.cfg.TickSize <= 0 {
 			return errors.New("tick size must be positive for limit orders")
 		}
 		if order.Price <= 0 || order.Price%ob.cfg.TickSize != 0 {
-			return fmt.Errorf("price must align to tick size %d", ob.cfg.TickSize)
+			return orderError(OrderBadTick, "price must align to tick size %d", ob.cfg.TickSize)
+		}
+	}
+	isStop := order.Type == Stop || order.Type == StopLimit
+	if isStop {
+		if ob.cfg.TickSize <= 0 || order.StopPrice <= 0 || order.StopPrice%ob.cfg.TickSize != 0 {
+			return orderError(OrderBadTick, "stop price must align to tick size %d", ob.cfg.TickSize)
+		}
+		if ob.lastPrice > 0 {
+			if order.Side == Buy && order.StopPrice <= ob.lastPrice {
+				return fmt.Errorf("buy stop price must be above last trade price %d", ob.lastPrice)
+			}
+			if order.Side == Sell && order.StopPrice >= ob.lastPrice {
+				return fmt.Errorf("sell stop price must be below last trade price %d", ob.lastPrice)
+			}
+		}
+	}
+
+	switch order.TimeInForce {
+	case GTC, IOC, FOK:
+		order.ExpireAt = time.Time{}
+	case DAY:
+		if order.Type == Market {
+			return errors.New("market orders cannot be DAY orders")
+		}
+		order.ExpireAt = endOfDay(now)
+	case GTD:
+		if order.Type == Market {
+			return errors.New("market orders cannot be GTD orders")
+		}
+		if order.ExpireAt.IsZero() {
+			return errors.New("GTD orders require an expiry time")
 		}
+		if !order.ExpireAt.After(now) {
+			return errors.New("expiry time must be in the future")
+		}
+	default:
+		return fmt.Errorf("unknown time in force %d", order.TimeInForce)
 	}
 
+	if order.PostOnly != PostOnlyOff && (order.Type != Limit || order.TimeInForce == IOC || order.TimeInForce == FOK) {
+		return errors.New("post-only requires a resting limit order")
+	}
+	if order.DisplayQuantity != 0 {
+		if order.Type != Limit && order.Type != StopLimit {
+			return errors.New("display quantity requires a limit order")
+		}
+		if order.DisplayQuantity < 0 || order.DisplayQuantity > order.Quantity {
+			return errors.New("display quantity must be between 1 and the order quantity")
+		}
+	}
+	order.Remaining = order.Quantity
+	if !isStop {
+		return ob.fillable(order)
+	}
+	return nil
+}
+
+// fillable rejects a fill-or-kill order the book cannot fill completely.
+func (ob *OrderBook) fillable(order *Order) error {
+	if order.TimeInForce != FOK {
+		return nil
+	}
+	opposing := &ob.bids
+	if order.Side == Buy {
+		opposing = &ob.asks
+	}
+	if ob.available(order, opposing) < order.Quantity {
+		return errors.New("fill-or-kill order cannot be fully filled")
+	}
+	return nil
+}
+
+// execute sequences a validated order and matches it against the book. A
+// triggered stop was reported new when it was accepted, so it reports
+// replaced instead.
+func (ob *OrderBook) execute(order *Order, now time.Time, triggered bool) error {
 	ob.seq++
 	order.Sequence = ob.seq
-	order.Timestamp = ob.now()
-	order.Remaining = order.Quantity
+	order.Timestamp = now
 
 	if order.Side == Buy {
-		ob.match(&order, &ob.asks, &ob.bids, false)
-	} else {
-		ob.match(&order, &ob.bids, &ob.asks, true)
+		return ob.match(order, &ob.asks, &ob.bids, triggered)
 	}
+	return ob.match(order, &ob.bids, &ob.asks, triggered)
+}
 
-	return nil
+// fireTriggers releases stop orders whose stop price has been reached by the
+// last trade. Each released order may trade and move the last price, so the
+// trigger book is re-checked until nothing else fires.
+func (ob *OrderBook) fireTriggers() {
+	for {
+		order, ok := ob.triggers.next(ob.lastPrice)
+		if !ok {
+			return
+		}
+		if order.Type == Stop {
+			order.Type = Market
+		} else {
+			order.Type = Limit
+		}
+		err := ob.fillable(order)
+		if err == nil {
+			err = ob.execute(order, ob.now(), true)
+		}
+		if err != nil {
+			ob.report(order, StateCanceled, 0, 0, ReasonStopNotExecuted+": "+err.Error())
+		}
+	}
+}
+
+// available sums the opposing quantity the incoming order could trade against
+// at its limit price, stopping early once the order's size is covered.
+func (ob *OrderBook) available(incoming *Order, opposing *bookSide) int64 {
+	var total int64
+	opposing.each(func(entry *orderEntry) bool {
+		if !crosses(incoming, entry.order.Price) {
+			return false
+		}
+		if selfTrade(incoming, entry.order) {
+			// Only cancel-oldest lets the order trade past its own.
+			return incoming.SelfTrade == SelfTradeCancelOldest
+		}
+		total += entry.order.Remaining
+		return total < incoming.Remaining
+	})
+	return total
 }
 
-func (ob *OrderBook) match(incoming *Order, opposing *priceTimeQueue, resting *priceTimeQueue, opposingIsBid bool) {
+func crosses(incoming *Order, price int64) bool {
+	if incoming.Type != Limit {
+		return true
+	}
+	if incoming.Side == Buy {
+		return incoming.Price >= price
+	}
+	return incoming.Price <= price
+}
+
+func (ob *OrderBook) match(incoming *Order, opposing *bookSide, resting *bookSide, triggered bool) error {
+	if incoming.PostOnly != PostOnlyOff {
+		if err := ob.applyPostOnly(incoming, opposing); err != nil {
+			return err
+		}
+	}
+	if triggered {
+		ob.report(incoming, StateReplaced, 0, 0, ReasonStopTriggered)
+	} else {
+		ob.report(incoming, StateNew, 0, 0, "")
+	}
+
 	for incoming.Remaining > 0 {
 		best := opposing.peek()
 		if best == nil {
 			break
 		}
-		if incoming.Type == Limit {
-			if incoming.Side == Buy && incoming.Price < best.order.Price {
-				break
-			}
-			if incoming.Side == Sell && incoming.Price > best.order.Price {
-				break
+		if !crosses(incoming, best.order.Price) {
+			break
+		}
+		if selfTrade(incoming, best.order) {
+			if ob.preventSelfTrade(incoming, best) {
+				return nil
 			}
+			continue
 		}
 
-		tradedQty := min(incoming.Remaining, best.order.Remaining)
+		tradedQty := min(incoming.Remaining, best.visible)
 		tradePrice := best.order.Price
 		incoming.Remaining -= tradedQty
 		best.order.Remaining -= tradedQty
+		opposing.setVisible(best, best.visible-tradedQty)
+		ob.emitL3(L3Execute, best, tradedQty, tradePrice)
+		incoming.filled += tradedQty
+		best.order.filled += tradedQty
+		incoming.notional += tradePrice * tradedQty
+		best.order.notional += tradePrice * tradedQty
+		ob.lastPrice = tradePrice
+		// Counted during replay too, so trade IDs carry on where they were.
+		ob.tradeSeq++
 
-		ob.trades <- MatchResult{
-			Symbol:      incoming.Symbol,
-			BuyOrderID:  selectOrderID(incoming, best.order, Buy),
-			SellOrderID: selectOrderID(incoming, best.order, Sell),
-			Price:       tradePrice,
-			Quantity:    tradedQty,
-			Timestamp:   ob.now(),
+		notional := tradePrice * tradedQty
+		takerFee := ob.cfg.Fees.fee(incoming.Owner, incoming.Symbol, notional, false)
+		makerFee := ob.cfg.Fees.fee(best.order.Owner, incoming.Symbol, notional, true)
+		if !ob.replaying {
+			trade := MatchResult{
+				TradeID:       incoming.Symbol + "-" + strconv.FormatInt(ob.tradeSeq, 10),
+				Symbol:        incoming.Symbol,
+				BuyOrderID:    selectOrderID(incoming, best.order, Buy),
+				SellOrderID:   selectOrderID(incoming, best.order, Sell),
+				MakerOrderID:  best.order.ID,
+				TakerOrderID:  incoming.ID,
+				AggressorSide: incoming.Side,
+				Price:         tradePrice,
+				Quantity:      tradedQty,
+				BuyFee:        takerFee,
+				SellFee:       makerFee,
+				Sequence:      ob.l3Seq,
+				Timestamp:     ob.now(),
+			}
+			if incoming.Side == Sell {
+				trade.BuyFee, trade.SellFee = makerFee, takerFee
+			}
+			ob.tradeFeed.publish(trade)
 		}
+		ob.reportFill(incoming, tradedQty, tradePrice, takerFee, false)
+		ob.reportFill(best.order, tradedQty, tradePrice, makerFee, true)
 
 		if best.order.Remaining == 0 {
-			entry := heap.Pop(opposing).(*orderEntry)
+			opposing.remove(best)
 			delete(ob.orders, best.order.ID)
-			ob.releaseEntry(entry)
-		} else {
-			heap.Fix(opposing, best.index)
+			ob.releaseEntry(best)
+		} else if best.visible == 0 {
+			ob.replenish(best)
 		}
 	}
 
-	if incoming.Remaining > 0 && incoming.Type == Limit {
+	if incoming.Remaining == 0 {
+		return nil
+	}
+	switch {
+	case incoming.Type != Limit:
+		ob.report(incoming, StateCanceled, 0, 0, ReasonMarketRemainder)
+	case incoming.TimeInForce == IOC || incoming.TimeInForce == FOK:
+		ob.report(incoming, StateCanceled, 0, 0, ReasonIOCRemainder)
+	default:
 		entry := ob.newEntry(incoming)
-		heap.Push(resting, entry)
+		resting.push(entry)
 		ob.orders[incoming.ID] = entry
-		if incoming.Side == Buy {
-			trimDepth(resting, ob.cfg.MaxDepth, true, ob.orders, ob.releaseEntry)
+		ob.emitL3(L3Add, entry, entry.visible, entry.order.Price)
+		if !incoming.ExpireAt.IsZero() {
+			heap.Push(&ob.expiries, expiryItem{at: incoming.ExpireAt, id: incoming.ID})
+		}
+		trimDepth(resting, ob.cfg.MaxDepth, ob.orders, ob.evictTrimmed)
+	}
+	return nil
+}
+
+// selfTrade reports whether incoming must not trade with resting.
+func selfTrade(incoming, resting *Order) bool {
+	return incoming.SelfTrade != SelfTradeAllow && incoming.Owner != "" && incoming.Owner == resting.Owner
+}
+
+// preventSelfTrade applies the incoming order's self-trade mode against a
+// resting order of the same owner and reports whether the incoming order is
+// done.
+func (ob *OrderBook) preventSelfTrade(incoming *Order, resting *orderEntry) bool {
+	switch incoming.SelfTrade {
+	case SelfTradeCancelOldest:
+		ob.cancelResting(resting, ReasonSelfTrade)
+		return false
+	case SelfTradeCancelBoth:
+		ob.cancelResting(resting, ReasonSelfTrade)
+	case SelfTradeDecrement:
+		qty := min(incoming.Remaining, resting.order.Remaining)
+		if resting.order.Remaining == qty {
+			ob.cancelResting(resting, ReasonSelfTrade)
 		} else {
-			trimDepth(resting, ob.cfg.MaxDepth, false, ob.orders, ob.releaseEntry)
+			ob.decrementResting(resting, qty)
+		}
+		if incoming.Remaining > qty {
+			incoming.Quantity -= qty
+			incoming.Remaining -= qty
+			ob.report(incoming, StateReplaced, 0, 0, ReasonSelfTrade)
+			return false
+		}
+	}
+	ob.report(incoming, StateCanceled, 0, 0, ReasonSelfTrade)
+	return true
+}
+
+// cancelResting takes a resting order off the book and reports it canceled.
+func (ob *OrderBook) cancelResting(entry *orderEntry, reason string) {
+	ob.report(entry.order, StateCanceled, 0, 0, reason)
+	ob.emitL3(L3Delete, entry, 0, entry.order.Price)
+	ob.side(entry.isBid).remove(entry)
+	delete(ob.orders, entry.order.ID)
+	ob.releaseEntry(entry)
+}
+
+// decrementResting shrinks a resting order by qty for self-trade prevention.
+// Unlike an amend, it keeps the order's place in the queue.
+func (ob *OrderBook) decrementResting(entry *orderEntry, qty int64) {
+	entry.order.Quantity -= qty
+	entry.order.Remaining -= qty
+	if entry.order.DisplayQuantity > entry.order.Quantity {
+		entry.order.DisplayQuantity = entry.order.Quantity
+	}
+	ob.side(entry.isBid).setVisible(entry, min(entry.visible, entry.order.Remaining))
+	ob.emitL3(L3Modify, entry, entry.visible, entry.order.Price)
+	ob.report(entry.order, StateReplaced, 0, 0, ReasonSelfTrade)
+}
+
+func fillState(order *Order) OrderState {
+	if order.Remaining == 0 {
+		return StateFilled
+	}
+	return StatePartiallyFilled
+}
+
+// evictTrimmed reports and recycles an order pushed out by trimDepth.
+func (ob *OrderBook) evictTrimmed(entry *orderEntry) {
+	ob.report(entry.order, StateCanceled, 0, 0, ReasonDepthTrimmed)
+	ob.emitL3(L3Delete, entry, 0, entry.order.Price)
+	ob.releaseEntry(entry)
+}
+
+// report publishes an execution report without blocking the matching loop.
+func (ob *OrderBook) report(order *Order, state OrderState, lastQty, lastPrice int64, reason string) {
+	ob.finish(order, state)
+	if ob.replaying {
+		return
+	}
+	ob.reportFeed.publish(ob.executionReport(order, state, lastQty, lastPrice, reason))
+}
+
+// reportFill publishes the execution report for one fill of an order.
+func (ob *OrderBook) reportFill(order *Order, qty, price, fee int64, maker bool) {
+	state := fillState(order)
+	ob.finish(order, state)
+	if ob.replaying {
+		return
+	}
+	rep := ob.executionReport(order, state, qty, price, "")
+	rep.Fee, rep.Maker = fee, maker
+	ob.reportFeed.publish(rep)
+}
+
+// finish remembers an order that reached a terminal state, including during
+// replay, so later requests for it get a precise error.
+func (ob *OrderBook) finish(order *Order, state OrderState) {
+	switch state {
+	case StateFilled, StateCanceled, StateRejected, StateExpired:
+		if order.ID != "" {
+			ob.history.record(order, state, ob.now())
 		}
 	}
 }
 
+func (ob *OrderBook) executionReport(order *Order, state OrderState, lastQty, lastPrice int64, reason string) ExecutionReport {
+	rep := ExecutionReport{
+		OrderID:       order.ID,
+		ClientOrderID: order.ClientOrderID,
+		Owner:         order.Owner,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Type:          order.Type,
+		State:         state,
+		Price:         order.Price,
+		Quantity:      order.Quantity,
+		LastPrice:     lastPrice,
+		LastQty:       lastQty,
+		CumQty:        order.filled,
+		LeavesQty:     order.Remaining,
+		Reason:        reason,
+		Timestamp:     ob.now(),
+	}
+	switch state {
+	case StateCanceled, StateRejected, StateExpired:
+		rep.LeavesQty = 0
+	}
+	return rep
+}
+
+// replenish refreshes an iceberg's visible slice from its reserve. The new
+// slice loses time priority and joins the back of the queue at its price.
+func (ob *OrderBook) replenish(entry *orderEntry) {
+	side := ob.side(entry.isBid)
+	side.setVisible(entry, displaySlice(entry.order))
+	side.requeue(entry)
+	ob.seq++
+	entry.order.Sequence = ob.seq
+	entry.order.Timestamp = ob.now()
+	ob.emitL3(L3Add, entry, entry.visible, entry.order.Price)
+}
+
+// applyPostOnly keeps a post-only order from taking liquidity, either by
+// rejecting it or by sliding its price one tick behind the opposite best.
+func (ob *OrderBook) applyPostOnly(incoming *Order, opposing *bookSide) error {
+	best := opposing.peek()
+	if best == nil || !crosses(incoming, best.order.Price) {
+		return nil
+	}
+	if incoming.PostOnly != PostOnlySlide {
+		return errors.New("post-only order would take liquidity")
+	}
+	if incoming.Side == Buy {
+		incoming.Price = best.order.Price - ob.cfg.TickSize
+	} else {
+		incoming.Price = best.order.Price + ob.cfg.TickSize
+	}
+	if incoming.Price <= 0 {
+		return errors.New("post-only order cannot slide below the minimum price")
+	}
+	return nil
+}
+
 func selectOrderID(incoming, resting *Order, side Side) string {
 	if incoming.Side == side {
 		return incoming.ID
@@ -329,53 +1106,263 @@ func selectOrderID(incoming, resting *Order, side Side) string {
 }
 
 func (ob *OrderBook) processCancel(id string) error {
-	entry, ok := ob.orders[id]
-	if !ok {
-		return fmt.Errorf("order %s not found", id)
+	now := ob.now()
+	ob.expireOrders(now)
+
+	if _, err := ob.workingOrder(id); err != nil {
+		return err
 	}
-	if entry.isBid {
-		ob.releaseEntry(ob.bids.remove(entry))
-	} else {
-		ob.releaseEntry(ob.asks.remove(entry))
+	return ob.cancelWorking(id, ReasonCanceled, now)
+}
+
+// processBatch adds each order in turn and reports whether any was accepted.
+func (ob *OrderBook) processBatch(orders []Order) ([]error, bool) {
+	errs := make([]error, len(orders))
+	changed := false
+	for i, order := range orders {
+		errs[i] = ob.processAdd(order)
+		changed = changed || errs[i] == nil
+	}
+	return errs, changed
+}
+
+// processMassCancel cancels the working orders that match filter: resting
+// bids, then asks, each in priority order, then pending stops.
+func (ob *OrderBook) processMassCancel(filter CancelFilter) ([]Order, error) {
+	now := ob.now()
+	ob.expireOrders(now)
+
+	matches := func(order *Order) bool {
+		return (filter.Owner == "" || order.Owner == filter.Owner) && (filter.Side == nil || order.Side == *filter.Side)
+	}
+	var targets []Order
+	for _, side := range []*bookSide{&ob.bids, &ob.asks} {
+		side.each(func(entry *orderEntry) bool {
+			if matches(entry.order) {
+				targets = append(targets, *entry.order)
+			}
+			return true
+		})
+	}
+	for _, stops := range [][]*Order{ob.triggers.buys, ob.triggers.sells} {
+		for _, stop := range stops {
+			if matches(stop) {
+				targets = append(targets, *stop)
+			}
+		}
+	}
+
+	for i := range targets {
+		if err := ob.cancelWorking(targets[i].ID, ReasonMassCanceled, now); err != nil {
+			return targets[:i], err
+		}
+	}
+	return targets, nil
+}
+
+// workingOrder finds a resting or pending stop order, or says why there is
+// none.
+func (ob *OrderBook) workingOrder(id string) (*Order, error) {
+	if entry, ok := ob.orders[id]; ok {
+		return entry.order, nil
+	}
+	if stop, ok := ob.triggers.get(id); ok {
+		return stop, nil
+	}
+	return nil, ob.history.missing(id)
+}
+
+// cancelWorking journals and carries out the cancel of a working order.
+func (ob *OrderBook) cancelWorking(id, reason string, now time.Time) error {
+	if err := ob.journalAppend(JournalRecord{Op: JournalCancel, Sequence: ob.seq, Timestamp: now, OrderID: id}); err != nil {
+		return err
+	}
+	if entry, ok := ob.orders[id]; ok {
+		ob.cancelResting(entry, reason)
+		return nil
+	}
+	stop, _ := ob.triggers.remove(id)
+	ob.report(stop, StateCanceled, 0, 0, reason)
+	return nil
+}
+
+// processReplace cancels id and admits its replacement. The replacement is
+// validated first, so a bad one leaves the original working; it is journaled
+// as a cancel followed by an add.
+func (ob *OrderBook) processReplace(id, newID string, newPrice *int64, newQty *int64) error {
+	now := ob.now()
+	ob.expireOrders(now)
+
+	original, err := ob.workingOrder(id)
+	if err != nil {
+		return err
+	}
+	if newID == "" {
+		return errors.New("replacement order id is required")
+	}
+	if err := ob.checkNewID(newID, now); err != nil {
+		return err
+	}
+	replacement := Order{
+		ID:              newID,
+		Symbol:          original.Symbol,
+		Side:            original.Side,
+		Type:            original.Type,
+		Price:           original.Price,
+		StopPrice:       original.StopPrice,
+		Quantity:        original.Remaining,
+		DisplayQuantity: original.DisplayQuantity,
+		TimeInForce:     original.TimeInForce,
+		ExpireAt:        original.ExpireAt,
+		PostOnly:        original.PostOnly,
+		Owner:           original.Owner,
+		SelfTrade:       original.SelfTrade,
+		ClientOrderID:   original.ClientOrderID,
+	}
+	if newPrice != nil {
+		replacement.Price = *newPrice
+	}
+	if newQty != nil {
+		replacement.Quantity = *newQty
+	}
+	if replacement.DisplayQuantity > replacement.Quantity {
+		replacement.DisplayQuantity = replacement.Quantity
+	}
+	if err := ob.validate(&replacement, now); err != nil {
+		return err
+	}
+	if replacement.PostOnly != PostOnlyOff {
+		// Post-only is otherwise only checked while matching, after the
+		// original would be gone.
+		probe := replacement
+		if err := ob.applyPostOnly(&probe, ob.side(replacement.Side == Sell)); err != nil {
+			return err
+		}
+	}
+
+	if err := ob.cancelWorking(id, ReasonReplaced, now); err != nil {
+		return err
+	}
+	if err := ob.admit(&replacement, now); err != nil {
+		ob.report(&replacement, StateRejected, 0, 0, err.Error())
+		return err
 	}
-	delete(ob.orders, id)
 	return nil
 }
 
 func (ob *OrderBook) processAmend(id string, newPrice *int64, newQty *int64) error {
+	now := ob.now()
+	ob.expireOrders(now)
+
 	entry, ok := ob.orders[id]
 	if !ok {
-		return fmt.Errorf("order %s not found", id)
+		if _, ok := ob.triggers.get(id); ok {
+			return orderError(OrderPendingStop, "order %s is a pending stop and cannot be amended", id)
+		}
+		return ob.history.missing(id)
 	}
-	if newQty != nil {
-		if *newQty <= 0 {
-			return errors.New("amended quantity must be positive")
+	if newQty != nil && *newQty <= 0 {
+		return orderError(OrderBadQuantity, "amended quantity must be positive")
+	}
+	if newQty != nil && *newQty <= entry.order.filled {
+		return orderError(OrderBadQuantity, "amended quantity must exceed the %d already filled", entry.order.filled)
+	}
+	if newPrice != nil && (*newPrice <= 0 || ob.cfg.TickSize <= 0 || *newPrice%ob.cfg.TickSize != 0) {
+		return orderError(OrderBadTick, "price must align to tick size %d", ob.cfg.TickSize)
+	}
+	// An amended order only rests; one that would trade must be replaced.
+	if newPrice != nil {
+		if best := ob.side(!entry.isBid).peek(); best != nil {
+			if (entry.isBid && *newPrice >= best.order.Price) || (!entry.isBid && *newPrice <= best.order.Price) {
+				return orderError(OrderWouldCross, "price %d would cross the best opposite price %d", *newPrice, best.order.Price)
+			}
 		}
+	}
+	if err := ob.journalAppend(JournalRecord{Op: JournalAmend, Sequence: ob.seq + 1, Timestamp: now, OrderID: id, Price: newPrice, Quantity: newQty}); err != nil {
+		return err
+	}
+
+	side := ob.side(entry.isBid)
+	side.remove(entry)
+	if newQty != nil {
 		entry.order.Quantity = *newQty
-		if entry.order.Remaining > *newQty {
-			entry.order.Remaining = *newQty
+		entry.order.Remaining = *newQty - entry.order.filled
+		if entry.order.DisplayQuantity > *newQty {
+			entry.order.DisplayQuantity = *newQty
 		}
 	}
 	if newPrice != nil {
-		if *newPrice <= 0 || ob.cfg.TickSize <= 0 || *newPrice%ob.cfg.TickSize != 0 {
-			return fmt.Errorf("price must align to tick size %d", ob.cfg.TickSize)
-		}
 		entry.order.Price = *newPrice
 	}
 	ob.seq++
 	entry.order.Sequence = ob.seq
-	entry.order.Timestamp = ob.now()
+	entry.order.Timestamp = now
+	entry.visible = displaySlice(entry.order)
+	side.push(entry)
+	ob.emitL3(L3Modify, entry, entry.visible, entry.order.Price)
+	ob.report(entry.order, StateReplaced, 0, 0, "")
 
-	if entry.isBid {
-		heap.Fix(&ob.bids, entry.index)
-		trimDepth(&ob.bids, ob.cfg.MaxDepth, true, ob.orders, ob.releaseEntry)
-	} else {
-		heap.Fix(&ob.asks, entry.index)
-		trimDepth(&ob.asks, ob.cfg.MaxDepth, false, ob.orders, ob.releaseEntry)
-	}
+	trimDepth(side, ob.cfg.MaxDepth, ob.orders, ob.evictTrimmed)
 	return nil
 }
 
+// expireOrders removes every resting order whose deadline is at or before now
+// and reports whether the book changed.
+func (ob *OrderBook) expireOrders(now time.Time) bool {
+	if len(ob.expiries) == 0 {
+		return false
+	}
+	expired := false
+	for len(ob.expiries) > 0 && !ob.expiries[0].at.After(now) {
+		item := heap.Pop(&ob.expiries).(expiryItem)
+		if stop, ok := ob.triggers.get(item.id); ok && stop.ExpireAt.Equal(item.at) {
+			ob.triggers.remove(item.id)
+			ob.report(stop, StateExpired, 0, 0, "")
+			continue
+		}
+		entry, ok := ob.orders[item.id]
+		if !ok || !entry.order.ExpireAt.Equal(item.at) {
+			continue
+		}
+		ob.report(entry.order, StateExpired, 0, 0, "")
+		ob.emitL3(L3Delete, entry, 0, entry.order.Price)
+		ob.side(entry.isBid).remove(entry)
+		ob.releaseEntry(entry)
+		delete(ob.orders, item.id)
+		expired = true
+	}
+	return expired
+}
+
+// nextExpiry returns the earliest pending deadline, or the zero time if none.
+func (ob *OrderBook) nextExpiry() time.Time {
+	for len(ob.expiries) > 0 {
+		item := ob.expiries[0]
+		if entry, ok := ob.orders[item.id]; ok && entry.order.ExpireAt.Equal(item.at) {
+			return item.at
+		}
+		if stop, ok := ob.triggers.get(item.id); ok && stop.ExpireAt.Equal(item.at) {
+			return item.at
+		}
+		heap.Pop(&ob.expiries)
+	}
+	return time.Time{}
+}
+
+// endOfDay returns the next UTC midnight after t, when DAY orders expire.
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// side returns the bid or ask side of the book.
+func (ob *OrderBook) side(isBid bool) *bookSide {
+	if isBid {
+		return &ob.bids
+	}
+	return &ob.asks
+}
+
 func min(a, b int64) int64 {
 	if a < b {
 		return a
@@ -384,27 +1371,35 @@ func min(a, b int64) int64 {
 }
 
 func (ob *OrderBook) handleSnapshot(view chan<- BookView, resp chan<- error) {
+	ob.expireOrders(ob.now())
 	view <- ob.snapshotView()
 	resp <- nil
 }
 
 func (ob *OrderBook) snapshotView() BookView {
-	snapshot := BookView{}
+	snapshot := BookView{Symbol: ob.cfg.Symbol}
 	if best := ob.bids.peek(); best != nil {
-		copy := *best.order
-		snapshot.BestBid = &copy
+		snapshot.BestBid = publicCopy(best)
 	}
 	if best := ob.asks.peek(); best != nil {
-		copy := *best.order
-		snapshot.BestAsk = &copy
+		snapshot.BestAsk = publicCopy(best)
 	}
 	return snapshot
 }
 
+// publicCopy copies a resting order for market data, hiding any iceberg reserve.
+func publicCopy(entry *orderEntry) *Order {
+	copy := *entry.order
+	if copy.DisplayQuantity > 0 {
+		copy.Quantity = copy.DisplayQuantity
+		copy.Remaining = entry.visible
+		copy.DisplayQuantity = 0
+	}
+	return &copy
+}
+
 func (ob *OrderBook) publishView() {
+	ob.publishDepth()
 	view := ob.snapshotView()
-	select {
-	case ob.updates <- view:
-	default:
-	}
+	ob.updateFeed.publish(view)
 }
@@ -1,21 +1,23 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 func TestLimitMatch(t *testing.T) {
-	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10})
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
 	defer ob.Stop()
 
-	ob.now = func() time.Time { return time.Unix(0, 0) }
-
 	if err := ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 101, Quantity: 5}); err != nil {
 		t.Fatalf("failed to add ask: %v", err)
 	}
 
-	ob.now = func() time.Time { return time.Unix(1, 0) }
+	clock.Set(time.Unix(1, 0))
 	if err := ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 102, Quantity: 3}); err != nil {
 		t.Fatalf("failed to add bid: %v", err)
 	}
@@ -27,14 +29,14 @@ func TestLimitMatch(t *testing.T) {
 }
 
 func TestMarketOrderConsumesBest(t *testing.T) {
-	ob := NewOrderBook(OrderBookConfig{Symbol: "ETHUSD", TickSize: 1, MaxDepth: 10})
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "ETHUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
 	defer ob.Stop()
-	ob.now = func() time.Time { return time.Unix(0, 0) }
 
 	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "ETHUSD", Side: Sell, Type: Limit, Price: 50, Quantity: 2})
 	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "ETHUSD", Side: Sell, Type: Limit, Price: 55, Quantity: 5})
 
-	ob.now = func() time.Time { return time.Unix(1, 0) }
+	clock.Set(time.Unix(1, 0))
 	if err := ob.SubmitOrder(Order{ID: "mkt1", Symbol: "ETHUSD", Side: Buy, Type: Market, Quantity: 4}); err != nil {
 		t.Fatalf("submit market order: %v", err)
 	}
@@ -51,9 +53,9 @@ func TestMarketOrderConsumesBest(t *testing.T) {
 }
 
 func TestAmendAndCancel(t *testing.T) {
-	ob := NewOrderBook(OrderBookConfig{Symbol: "SOLUSD", TickSize: 1, MaxDepth: 5})
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "SOLUSD", TickSize: 1, MaxDepth: 5, Clock: clock})
 	defer ob.Stop()
-	ob.now = func() time.Time { return time.Unix(0, 0) }
 
 	if err := ob.SubmitOrder(Order{ID: "bid1", Symbol: "SOLUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1}); err != nil {
 		t.Fatalf("failed to add bid1: %v", err)
@@ -82,28 +84,49 @@ func TestAmendAndCancel(t *testing.T) {
 }
 
 func TestMaxDepthTrimming(t *testing.T) {
-	ob := NewOrderBook(OrderBookConfig{Symbol: "ADAUSD", TickSize: 1, MaxDepth: 2})
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "ADAUSD", TickSize: 1, MaxDepth: 2, Clock: clock})
 	defer ob.Stop()
-	ob.now = func() time.Time { return time.Unix(0, 0) }
 
 	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "ADAUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1})
-	ob.now = func() time.Time { return time.Unix(1, 0) }
+	clock.Set(time.Unix(1, 0))
 	_ = ob.SubmitOrder(Order{ID: "bid2", Symbol: "ADAUSD", Side: Buy, Type: Limit, Price: 9, Quantity: 1})
-	ob.now = func() time.Time { return time.Unix(2, 0) }
+	clock.Set(time.Unix(2, 0))
 	_ = ob.SubmitOrder(Order{ID: "bid3", Symbol: "ADAUSD", Side: Buy, Type: Limit, Price: 8, Quantity: 1})
 
-	if len(ob.bids) != 2 {
-		t.Fatalf("expected bids trimmed to depth 2, got %d", len(ob.bids))
+	if ob.bids.Len() != 2 {
+		t.Fatalf("expected bids trimmed to depth 2, got %d", ob.bids.Len())
 	}
 	if _, ok := ob.orders["bid3"]; ok {
 		t.Fatalf("lowest priority order should have been trimmed")
 	}
 }
 
+func TestPriorityFollowsSequenceNotClock(t *testing.T) {
+	clock := NewSimClock(time.Unix(10, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock, Inline: true})
+	defer ob.Stop()
+
+	if err := ob.SubmitOrder(Order{ID: "first", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1}); err != nil {
+		t.Fatalf("submit first: %v", err)
+	}
+	// A clock stepped backwards must not let a later order jump the queue.
+	clock.Set(time.Unix(5, 0))
+	if err := ob.SubmitOrder(Order{ID: "second", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1}); err != nil {
+		t.Fatalf("submit second: %v", err)
+	}
+	if err := ob.SubmitOrder(Order{ID: "taker", Symbol: "BTCUSD", Side: Buy, Type: Market, Quantity: 1}); err != nil {
+		t.Fatalf("submit taker: %v", err)
+	}
+	if trade := <-ob.Trades(); trade.SellOrderID != "first" || !trade.Timestamp.Equal(time.Unix(5, 0)) {
+		t.Fatalf("expected first to fill at the simulated time, got %+v", trade)
+	}
+}
+
 func TestSnapshotCopiesTopLevels(t *testing.T) {
-	ob := NewOrderBook(OrderBookConfig{Symbol: "XRPUSD", TickSize: 1, MaxDepth: 5})
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "XRPUSD", TickSize: 1, MaxDepth: 5, Clock: clock})
 	defer ob.Stop()
-	ob.now = func() time.Time { return time.Unix(0, 0) }
 
 	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "XRPUSD", Side: Buy, Type: Limit, Price: 10, Quantity: 1})
 	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "XRPUSD", Side: Sell, Type: Limit, Price: 12, Quantity: 1})
@@ -122,3 +145,663 @@ func TestSnapshotCopiesTopLevels(t *testing.T) {
 		t.Fatalf("snapshot should return copies, expected 10 got %d", second.BestBid.Price)
 	}
 }
+
+func TestIOCDoesNotRest(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+	if err := ob.SubmitOrder(Order{ID: "ioc1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 5, TimeInForce: IOC}); err != nil {
+		t.Fatalf("submit ioc: %v", err)
+	}
+
+	trade := <-ob.Trades()
+	if trade.Quantity != 2 {
+		t.Fatalf("unexpected trade %+v", trade)
+	}
+	view, _ := ob.Snapshot()
+	if view.BestBid != nil {
+		t.Fatalf("ioc remainder should not rest, got %+v", view.BestBid)
+	}
+}
+
+func TestFOKRejectedWithoutTouchingBook(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 105, Quantity: 2})
+
+	if err := ob.SubmitOrder(Order{ID: "fok1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 101, Quantity: 3, TimeInForce: FOK}); err == nil {
+		t.Fatalf("expected fok rejection")
+	}
+	view, _ := ob.Snapshot()
+	if view.BestAsk == nil || view.BestAsk.ID != "ask1" || view.BestAsk.Remaining != 2 {
+		t.Fatalf("rejected fok should leave book untouched, got %+v", view.BestAsk)
+	}
+
+	if err := ob.SubmitOrder(Order{ID: "fok2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 105, Quantity: 3, TimeInForce: FOK}); err != nil {
+		t.Fatalf("fok should fill across levels: %v", err)
+	}
+	first, second := <-ob.Trades(), <-ob.Trades()
+	if first.Quantity+second.Quantity != 3 {
+		t.Fatalf("unexpected fills %+v %+v", first, second)
+	}
+}
+
+func TestGTDOrderExpires(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Inline: true, Clock: clock})
+	defer ob.Stop()
+
+	if err := ob.SubmitOrder(Order{ID: "gtd1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 1, TimeInForce: GTD}); err == nil {
+		t.Fatalf("expected GTD without expiry to be rejected")
+	}
+	if err := ob.SubmitOrder(Order{ID: "gtd1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 1, TimeInForce: GTD, ExpireAt: time.Unix(10, 0)}); err != nil {
+		t.Fatalf("submit gtd: %v", err)
+	}
+
+	clock.Set(time.Unix(9, 0))
+	if view, _ := ob.Snapshot(); view.BestBid == nil {
+		t.Fatalf("gtd order expired early")
+	}
+
+	clock.Set(time.Unix(10, 0))
+	if view, _ := ob.Snapshot(); view.BestBid != nil {
+		t.Fatalf("gtd order should have expired, got %+v", view.BestBid)
+	}
+	if err := ob.CancelOrder("gtd1"); err == nil {
+		t.Fatalf("expired order should no longer be cancelable")
+	}
+}
+
+func TestGTDOrderExpiresOnWorkerTimer(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10})
+	defer ob.Stop()
+
+	expireAt := time.Now().Add(20 * time.Millisecond)
+	if err := ob.SubmitOrder(Order{ID: "gtd1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1, TimeInForce: GTD, ExpireAt: expireAt}); err != nil {
+		t.Fatalf("submit gtd: %v", err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case view := <-ob.BookUpdates():
+			if view.BestAsk == nil {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("gtd order was not expired by the worker")
+		}
+	}
+}
+
+func TestPostOnlyRejectsAndSlides(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+
+	if err := ob.SubmitOrder(Order{ID: "po1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 105, Quantity: 1, PostOnly: PostOnlyReject}); err == nil {
+		t.Fatalf("expected crossing post-only order to be rejected")
+	}
+	if err := ob.SubmitOrder(Order{ID: "po2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 105, Quantity: 1, PostOnly: PostOnlySlide}); err != nil {
+		t.Fatalf("slide post-only: %v", err)
+	}
+
+	view, _ := ob.Snapshot()
+	if view.BestBid == nil || view.BestBid.ID != "po2" || view.BestBid.Price != 95 {
+		t.Fatalf("expected post-only bid slid to 95, got %+v", view.BestBid)
+	}
+	if view.BestAsk == nil || view.BestAsk.Remaining != 2 {
+		t.Fatalf("post-only orders must not take liquidity, got %+v", view.BestAsk)
+	}
+	select {
+	case trade := <-ob.Trades():
+		t.Fatalf("unexpected trade %+v", trade)
+	default:
+	}
+}
+
+func TestStopOrdersTriggerInSequence(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 101, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "ask3", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 102, Quantity: 5})
+	stop1 := ob.SubscribeExecutionReports(SubscribeOptions{Policy: OutputSpill, OrderIDs: func(id string) bool { return id == "stop1" }})
+
+	if err := ob.SubmitOrder(Order{ID: "stop1", Symbol: "BTCUSD", Side: Buy, Type: Stop, StopPrice: 100, Quantity: 1}); err != nil {
+		t.Fatalf("submit stop: %v", err)
+	}
+	if err := ob.SubmitOrder(Order{ID: "stop2", Symbol: "BTCUSD", Side: Buy, Type: StopLimit, StopPrice: 101, Price: 102, Quantity: 2}); err != nil {
+		t.Fatalf("submit stop limit: %v", err)
+	}
+	if err := ob.SubmitOrder(Order{ID: "stop3", Symbol: "BTCUSD", Side: Buy, Type: Stop, StopPrice: 150, Quantity: 1}); err != nil {
+		t.Fatalf("submit far stop: %v", err)
+	}
+
+	stops, err := ob.StopOrders()
+	if err != nil || len(stops) != 3 {
+		t.Fatalf("expected 3 pending stops, got %d (%v)", len(stops), err)
+	}
+
+	if err := ob.SubmitOrder(Order{ID: "mkt1", Symbol: "BTCUSD", Side: Buy, Type: Market, Quantity: 1}); err != nil {
+		t.Fatalf("submit market: %v", err)
+	}
+
+	want := []struct {
+		buy   string
+		price int64
+		qty   int64
+	}{
+		{"mkt1", 100, 1},
+		{"stop1", 101, 1},
+		{"stop2", 102, 2},
+	}
+	for _, w := range want {
+		trade := <-ob.Trades()
+		if trade.BuyOrderID != w.buy || trade.Price != w.price || trade.Quantity != w.qty {
+			t.Fatalf("expected %+v, got %+v", w, trade)
+		}
+	}
+	// A stop is new once; triggering it reports replaced.
+	for _, w := range []struct {
+		state  OrderState
+		reason string
+	}{{StateNew, ""}, {StateReplaced, ReasonStopTriggered}, {StateFilled, ""}} {
+		if rep := <-stop1.C; rep.State != w.state || rep.Reason != w.reason {
+			t.Fatalf("expected stop1 to report %v %q, got %+v", w.state, w.reason, rep)
+		}
+	}
+
+	if err := ob.CancelOrder("stop3"); err != nil {
+		t.Fatalf("cancel pending stop: %v", err)
+	}
+	if stops, _ := ob.StopOrders(); len(stops) != 0 {
+		t.Fatalf("expected trigger book to be empty, got %+v", stops)
+	}
+	if err := ob.SubmitOrder(Order{ID: "stop4", Symbol: "BTCUSD", Side: Buy, Type: Stop, StopPrice: 100, Quantity: 1}); err == nil {
+		t.Fatalf("expected buy stop at or below last trade to be rejected")
+	}
+}
+
+func TestIcebergRefreshLosesPriority(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	if err := ob.SubmitOrder(Order{ID: "ice1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 10, DisplayQuantity: 2}); err != nil {
+		t.Fatalf("submit iceberg: %v", err)
+	}
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 3})
+
+	view, _ := ob.Snapshot()
+	if view.BestAsk == nil || view.BestAsk.ID != "ice1" || view.BestAsk.Remaining != 2 || view.BestAsk.Quantity != 2 {
+		t.Fatalf("expected only the display slice to be visible, got %+v", view.BestAsk)
+	}
+
+	if err := ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 4}); err != nil {
+		t.Fatalf("submit bid: %v", err)
+	}
+	first, second := <-ob.Trades(), <-ob.Trades()
+	if first.SellOrderID != "ice1" || first.Quantity != 2 {
+		t.Fatalf("expected visible slice to fill first, got %+v", first)
+	}
+	if second.SellOrderID != "ask2" || second.Quantity != 2 {
+		t.Fatalf("expected refreshed iceberg to queue behind ask2, got %+v", second)
+	}
+
+	if err := ob.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 9}); err != nil {
+		t.Fatalf("submit bid2: %v", err)
+	}
+	var fromIceberg int64
+	for i := 0; i < 5; i++ {
+		trade := <-ob.Trades()
+		if trade.SellOrderID == "ice1" {
+			fromIceberg += trade.Quantity
+		}
+	}
+	if fromIceberg != 8 {
+		t.Fatalf("expected iceberg reserve of 8 to trade against parent id, got %d", fromIceberg)
+	}
+}
+
+func TestExecutionReportsLifecycle(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 1, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "ioc1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 3, TimeInForce: IOC})
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 90, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 80, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "bad", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 0, Quantity: 1})
+	_ = ob.CancelOrder("bid1")
+
+	want := []struct {
+		id     string
+		state  OrderState
+		cum    int64
+		leaves int64
+		reason string
+	}{
+		{"ask1", StateNew, 0, 2, ""},
+		{"ioc1", StateNew, 0, 3, ""},
+		{"ioc1", StatePartiallyFilled, 2, 1, ""},
+		{"ask1", StateFilled, 2, 0, ""},
+		{"ioc1", StateCanceled, 2, 0, ReasonIOCRemainder},
+		{"bid1", StateNew, 0, 1, ""},
+		{"bid2", StateNew, 0, 1, ""},
+		{"bid2", StateCanceled, 0, 0, ReasonDepthTrimmed},
+		{"bad", StateRejected, 0, 0, "price must align to tick size 1"},
+		{"bid1", StateCanceled, 0, 0, ReasonCanceled},
+	}
+	for _, w := range want {
+		rep := <-ob.ExecutionReports()
+		if rep.OrderID != w.id || rep.State != w.state || rep.CumQty != w.cum || rep.LeavesQty != w.leaves || rep.Reason != w.reason {
+			t.Fatalf("expected %+v, got %+v", w, rep)
+		}
+	}
+}
+
+func TestDepthSnapshotAndUpdates(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 99, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 99, Quantity: 3})
+	_ = ob.SubmitOrder(Order{ID: "bid3", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 98, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 101, Quantity: 4, DisplayQuantity: 1})
+
+	depth, err := ob.Depth(1)
+	if err != nil {
+		t.Fatalf("depth: %v", err)
+	}
+	if depth.Sequence != 4 {
+		t.Fatalf("expected depth sequence 4, got %d", depth.Sequence)
+	}
+	if len(depth.Bids) != 1 || depth.Bids[0] != (PriceLevel{Price: 99, Quantity: 5, Orders: 2}) {
+		t.Fatalf("unexpected bid levels %+v", depth.Bids)
+	}
+	if len(depth.Asks) != 1 || depth.Asks[0] != (PriceLevel{Price: 101, Quantity: 1, Orders: 1}) {
+		t.Fatalf("iceberg reserve should be hidden from depth, got %+v", depth.Asks)
+	}
+	if full, _ := ob.Depth(0); len(full.Bids) != 2 {
+		t.Fatalf("expected two bid levels, got %+v", full.Bids)
+	}
+
+	for i := 0; i < 4; i++ {
+		<-ob.DepthUpdates()
+	}
+
+	_ = ob.SubmitOrder(Order{ID: "sell1", Symbol: "BTCUSD", Side: Sell, Type: Market, Quantity: 5})
+	update := <-ob.DepthUpdates()
+	if update.Sequence != 5 {
+		t.Fatalf("expected sequence 5, got %d", update.Sequence)
+	}
+	if len(update.Bids) != 1 || update.Bids[0] != (PriceLevel{Price: 99}) {
+		t.Fatalf("expected bid level 99 removed, got %+v", update.Bids)
+	}
+}
+
+func TestL3FeedRebuildsBook(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Clock: clock})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 3})
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 90, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "mkt1", Symbol: "BTCUSD", Side: Buy, Type: Market, Quantity: 4})
+	newPrice := int64(95)
+	_ = ob.AmendOrder("bid1", &newPrice, nil)
+	_ = ob.CancelOrder("ask2")
+
+	want := []L3Event{
+		{Sequence: 1, Type: L3Add, OrderID: "ask1", Price: 100, Quantity: 3},
+		{Sequence: 2, Type: L3Add, OrderID: "ask2", Price: 100, Quantity: 2},
+		{Sequence: 3, Type: L3Add, OrderID: "bid1", Price: 90, Quantity: 1},
+		{Sequence: 4, Type: L3Execute, OrderID: "ask1", Price: 100, Quantity: 3},
+		{Sequence: 5, Type: L3Execute, OrderID: "ask2", Price: 100, Quantity: 1},
+		{Sequence: 6, Type: L3Modify, OrderID: "bid1", Price: 95, Quantity: 1},
+		{Sequence: 7, Type: L3Delete, OrderID: "ask2", Price: 100},
+	}
+	for _, w := range want {
+		event := <-ob.L3Events()
+		if event.Sequence != w.Sequence || event.Type != w.Type || event.OrderID != w.OrderID || event.Price != w.Price || event.Quantity != w.Quantity {
+			t.Fatalf("expected %+v, got %+v", w, event)
+		}
+	}
+
+	snapshot, err := ob.L3Snapshot()
+	if err != nil {
+		t.Fatalf("l3 snapshot: %v", err)
+	}
+	if snapshot.Sequence != 7 || len(snapshot.Bids) != 1 || len(snapshot.Asks) != 0 || snapshot.Bids[0].Price != 95 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+}
+
+func TestSelfTradePreventionModes(t *testing.T) {
+	type report struct {
+		id     string
+		state  OrderState
+		leaves int64
+		reason string
+	}
+	cases := []struct {
+		name    string
+		mode    SelfTradeMode
+		reports []report
+		trades  int
+		resting []string
+	}{
+		{"cancel newest", SelfTradeCancelNewest, []report{{"in", StateCanceled, 0, ReasonSelfTrade}}, 0, []string{"own", "other"}},
+		{"cancel oldest", SelfTradeCancelOldest, []report{{"own", StateCanceled, 0, ReasonSelfTrade}, {"in", StateFilled, 0, ""}}, 1, []string{"other"}},
+		{"cancel both", SelfTradeCancelBoth, []report{{"own", StateCanceled, 0, ReasonSelfTrade}, {"in", StateCanceled, 0, ReasonSelfTrade}}, 0, []string{"other"}},
+		{"decrement", SelfTradeDecrement, []report{{"own", StateReplaced, 1, ReasonSelfTrade}, {"in", StateCanceled, 0, ReasonSelfTrade}}, 0, []string{"own", "other"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ob := NewOrderBook(OrderBookConfig{Symbol: "STP", TickSize: 1, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0))})
+			defer ob.Stop()
+
+			_ = ob.SubmitOrder(Order{ID: "own", Symbol: "STP", Side: Sell, Type: Limit, Price: 100, Quantity: 3, Owner: "acct"})
+			_ = ob.SubmitOrder(Order{ID: "other", Symbol: "STP", Side: Sell, Type: Limit, Price: 100, Quantity: 5, Owner: "else"})
+			for len(ob.ExecutionReports()) > 0 {
+				<-ob.ExecutionReports()
+			}
+
+			if err := ob.SubmitOrder(Order{ID: "in", Symbol: "STP", Side: Buy, Type: Limit, Price: 100, Quantity: 2, Owner: "acct", SelfTrade: tc.mode}); err != nil {
+				t.Fatalf("submit: %v", err)
+			}
+
+			if rep := <-ob.ExecutionReports(); rep.OrderID != "in" || rep.State != StateNew {
+				t.Fatalf("expected in to be accepted first, got %+v", rep)
+			}
+			for _, w := range tc.reports {
+				rep := <-ob.ExecutionReports()
+				if rep.OrderID != w.id || rep.State != w.state || rep.LeavesQty != w.leaves || rep.Reason != w.reason || rep.Owner != "acct" {
+					t.Fatalf("expected %+v, got %+v", w, rep)
+				}
+			}
+			if got := len(ob.Trades()); got != tc.trades {
+				t.Fatalf("expected %d trades, got %d", tc.trades, got)
+			}
+
+			snapshot, err := ob.L3Snapshot()
+			if err != nil {
+				t.Fatalf("l3 snapshot: %v", err)
+			}
+			var ids []string
+			for _, order := range snapshot.Asks {
+				ids = append(ids, order.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tc.resting) {
+				t.Fatalf("expected resting %v, got %v", tc.resting, ids)
+			}
+			if tc.mode == SelfTradeDecrement && (snapshot.Asks[0].Sequence != 1 || snapshot.Asks[0].Remaining != 1) {
+				t.Fatalf("a decremented order should keep its sequence, got %+v", snapshot.Asks[0])
+			}
+		})
+	}
+}
+
+func TestMatchResultIdentifiesMakerAndTaker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "BTCUSD.journal")
+	cfg := OrderBookConfig{Symbol: "BTCUSD", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Unix(0, 0)), Journal: JournalConfig{Path: path, SnapshotEvery: 3}}
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 101, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 101, Quantity: 2})
+
+	want := []MatchResult{
+		{TradeID: "BTCUSD-1", MakerOrderID: "ask1", TakerOrderID: "bid1", AggressorSide: Buy, Sequence: 3},
+		{TradeID: "BTCUSD-2", MakerOrderID: "ask2", TakerOrderID: "bid1", AggressorSide: Buy, Sequence: 4},
+	}
+	for _, w := range want {
+		trade := <-ob.Trades()
+		if trade.TradeID != w.TradeID || trade.MakerOrderID != w.MakerOrderID || trade.TakerOrderID != w.TakerOrderID ||
+			trade.AggressorSide != w.AggressorSide || trade.Sequence != w.Sequence || trade.BuyOrderID != "bid1" {
+			t.Fatalf("expected %+v, got %+v", w, trade)
+		}
+	}
+	for _, w := range want {
+		for event := range ob.L3Events() {
+			if event.Type != L3Execute {
+				continue
+			}
+			if event.Sequence != w.Sequence || event.OrderID != w.MakerOrderID {
+				t.Fatalf("trade %+v does not match its execute event %+v", w, event)
+			}
+			break
+		}
+	}
+	ob.snapshotWG.Wait()
+	ob.Stop()
+
+	// Trade IDs and sequences carry on after recovery rather than starting
+	// over: bid2 rests as event 5 and its execute is event 6.
+	recovered, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	defer recovered.Stop()
+	_ = recovered.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 1})
+	_ = recovered.SubmitOrder(Order{ID: "ask3", Symbol: "BTCUSD", Side: Sell, Type: Market, Quantity: 1})
+	if trade := <-recovered.Trades(); trade.TradeID != "BTCUSD-3" || trade.AggressorSide != Sell || trade.MakerOrderID != "bid2" || trade.Sequence != 6 {
+		t.Fatalf("unexpected trade after recovery %+v", trade)
+	}
+}
+
+func orderCode(err error) string {
+	var orderErr *OrderError
+	if errors.As(err, &orderErr) {
+		return orderErr.Code
+	}
+	return ""
+}
+
+func TestCancelReplaceAndPreciseErrors(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0))})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 2})
+	_ = ob.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 90, Quantity: 4, Owner: "alice"})
+	_ = ob.SubmitOrder(Order{ID: "bid3", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 85, Quantity: 1})
+	_ = ob.CancelOrder("bid3")
+	_ = ob.SubmitOrder(Order{ID: "ask5", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 120, Quantity: 3})
+	_ = ob.SubmitOrder(Order{ID: "lift", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 120, Quantity: 1})
+	price, badPrice, crossing := int64(95), int64(93), int64(90)
+	filledQty, moreQty := int64(1), int64(5)
+
+	cases := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"cancel unknown", ob.CancelOrder("nope"), OrderNotFound},
+		{"cancel filled", ob.CancelOrder("ask1"), OrderFilled},
+		{"amend canceled", ob.AmendOrder("bid3", &price, nil), OrderClosed},
+		{"amend off tick", ob.AmendOrder("bid2", &badPrice, nil), OrderBadTick},
+		{"amend to the filled quantity", ob.AmendOrder("ask5", nil, &filledQty), OrderBadQuantity},
+		{"amend across the book", ob.AmendOrder("ask5", &crossing, nil), OrderWouldCross},
+		{"replace filled", ob.ReplaceOrder("bid1", "bid9", &price, nil), OrderFilled},
+		{"replace off tick", ob.ReplaceOrder("bid2", "bid9", &badPrice, nil), OrderBadTick},
+		{"replace onto working id", ob.ReplaceOrder("bid2", "bid2", &price, nil), OrderDuplicateID},
+	}
+	for _, tc := range cases {
+		if code := orderCode(tc.err); code != tc.code {
+			t.Fatalf("%s: expected %s, got %v", tc.name, tc.code, tc.err)
+		}
+	}
+
+	// The amended quantity counts the lot already filled.
+	if err := ob.AmendOrder("ask5", nil, &moreQty); err != nil {
+		t.Fatalf("amend ask5: %v", err)
+	}
+	if status, _ := ob.OrderStatus("ask5"); status.LeavesQty != 4 || status.CumQty != 1 {
+		t.Fatalf("expected 4 left of 5 after the amend, got %+v", status)
+	}
+
+	if err := ob.ReplaceOrder("bid2", "bid4", &price, nil); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	snapshot, _ := ob.L3Snapshot()
+	if len(snapshot.Bids) != 1 {
+		t.Fatalf("expected only the replacement to rest, got %+v", snapshot.Bids)
+	}
+	if got := snapshot.Bids[0]; got.ID != "bid4" || got.Price != 95 || got.Remaining != 4 || got.Owner != "alice" {
+		t.Fatalf("unexpected replacement %+v", got)
+	}
+	if code := orderCode(ob.CancelOrder("bid2")); code != OrderClosed {
+		t.Fatalf("expected the original to be closed, got %q", code)
+	}
+}
+
+func TestOrderHistoryEvictsOldest(t *testing.T) {
+	h := newOrderHistory(2, 0)
+	for _, id := range []string{"a", "b", "a", "c"} {
+		h.record(&Order{ID: id}, StateCanceled, time.Unix(0, 0))
+	}
+	// "a" was finished again after "b", so it outlives it.
+	if len(h.byID) != 2 || orderCode(h.missing("b")) != OrderNotFound || orderCode(h.missing("a")) != OrderClosed {
+		t.Fatalf("unexpected history %+v", h.byID)
+	}
+}
+
+func TestOrderStatusAndOpenOrders(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0))})
+	defer ob.Stop()
+
+	_ = ob.SubmitOrder(Order{ID: "ask1", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 100, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "ask2", Symbol: "BTCUSD", Side: Sell, Type: Limit, Price: 110, Quantity: 1})
+	_ = ob.SubmitOrder(Order{ID: "bid1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 110, Quantity: 4, Owner: "alice"})
+	_ = ob.SubmitOrder(Order{ID: "bid2", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 90, Quantity: 1, Owner: "alice"})
+	_ = ob.SubmitOrder(Order{ID: "bid3", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 85, Quantity: 1, Owner: "alice"})
+	_ = ob.CancelOrder("bid3")
+
+	status, err := ob.OrderStatus("bid1")
+	if err != nil {
+		t.Fatalf("status bid1: %v", err)
+	}
+	if status.State != StatePartiallyFilled || status.CumQty != 2 || status.LeavesQty != 2 || status.AvgPrice != 105 {
+		t.Fatalf("unexpected bid1 status %+v", status)
+	}
+	if status, _ := ob.OrderStatus("ask1"); status.State != StateFilled || status.CumQty != 1 || status.AvgPrice != 100 {
+		t.Fatalf("unexpected ask1 status %+v", status)
+	}
+	if _, err := ob.OrderStatus("nope"); orderCode(err) != OrderNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	ids := func(statuses []OrderStatus) []string {
+		out := make([]string, len(statuses))
+		for i, s := range statuses {
+			out[i] = s.Order.ID
+		}
+		return out
+	}
+	open, _ := ob.Orders("alice", ScopeOpen)
+	if got := ids(open); len(got) != 2 || got[0] != "bid1" || got[1] != "bid2" {
+		t.Fatalf("unexpected open orders %v", got)
+	}
+	closed, _ := ob.Orders("alice", ScopeClosed)
+	if len(closed) != 1 || closed[0].Order.ID != "bid3" || closed[0].State != StateCanceled || closed[0].LeavesQty != 0 {
+		t.Fatalf("unexpected closed orders %+v", closed)
+	}
+	if all, _ := ob.Orders("", ScopeAll); len(all) != 5 {
+		t.Fatalf("expected every order, got %v", ids(all))
+	}
+}
+
+func TestDuplicateOrderIDsAreRejected(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10, Inline: true, Clock: clock, DedupWindow: time.Minute})
+	defer ob.Stop()
+
+	bid := Order{ID: "bid1", ClientOrderID: "my-1", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 100, Quantity: 1}
+	if err := ob.SubmitOrder(bid); err != nil {
+		t.Fatalf("submit bid1: %v", err)
+	}
+	if rep := <-ob.ExecutionReports(); rep.OrderID != "bid1" || rep.ClientOrderID != "my-1" || rep.State != StateNew {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+	if code := orderCode(ob.SubmitOrder(bid)); code != OrderDuplicateID {
+		t.Fatalf("expected a working duplicate to be rejected, got %q", code)
+	}
+	if len(ob.ExecutionReports()) != 0 {
+		t.Fatalf("expected no report for the duplicate, got %+v", <-ob.ExecutionReports())
+	}
+
+	// The first order is untouched and can still be canceled.
+	if err := ob.CancelOrder("bid1"); err != nil {
+		t.Fatalf("cancel bid1: %v", err)
+	}
+	clock.Advance(59 * time.Second)
+	if code := orderCode(ob.SubmitOrder(bid)); code != OrderDuplicateID {
+		t.Fatalf("expected a recent id to be rejected, got %q", code)
+	}
+	clock.Advance(time.Second)
+	if err := ob.SubmitOrder(bid); err != nil {
+		t.Fatalf("expected the id to be free after the window: %v", err)
+	}
+}
+
+func TestBatchSubmitAndMassCancel(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "BTCUSD", TickSize: 5, MaxDepth: 10, Inline: true, Clock: NewSimClock(time.Unix(0, 0))})
+	defer ob.Stop()
+
+	quote := func(id, owner string, side Side, price int64) Order {
+		return Order{ID: id, Symbol: "BTCUSD", Side: side, Type: Limit, Price: price, Quantity: 1, Owner: owner}
+	}
+	errs := ob.SubmitOrders([]Order{
+		quote("mm-b1", "mm", Buy, 95),
+		quote("mm-b2", "mm", Buy, 93),
+		quote("mm-a1", "mm", Sell, 105),
+		quote("other-b1", "other", Buy, 90),
+		quote("mm-b1", "mm", Buy, 90),
+	})
+	if errs[0] != nil || errs[2] != nil || errs[3] != nil {
+		t.Fatalf("unexpected batch errors %v", errs)
+	}
+	if orderCode(errs[1]) != OrderBadTick || orderCode(errs[4]) != OrderDuplicateID {
+		t.Fatalf("expected bad tick and duplicate id, got %v", errs)
+	}
+	if len(ob.BookUpdates()) != 1 || len(ob.DepthUpdates()) != 1 {
+		t.Fatalf("expected one update for the batch, got %d views and %d depth updates", len(ob.BookUpdates()), len(ob.DepthUpdates()))
+	}
+	if update := <-ob.DepthUpdates(); len(update.Bids) != 2 || len(update.Asks) != 1 {
+		t.Fatalf("unexpected depth update %+v", update)
+	}
+	<-ob.BookUpdates()
+
+	buy := Buy
+	canceled, err := ob.CancelOrders(CancelFilter{Owner: "mm", Side: &buy})
+	if err != nil || len(canceled) != 1 || canceled[0].ID != "mm-b1" {
+		t.Fatalf("expected mm's bid canceled, got %+v, %v", canceled, err)
+	}
+	if canceled, _ = ob.CancelOrders(CancelFilter{Owner: "mm"}); len(canceled) != 1 || canceled[0].ID != "mm-a1" {
+		t.Fatalf("expected mm's ask canceled, got %+v", canceled)
+	}
+	if status, _ := ob.OrderStatus("mm-a1"); status.State != StateCanceled {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if snapshot, _ := ob.L3Snapshot(); len(snapshot.Bids) != 1 || snapshot.Bids[0].ID != "other-b1" || len(snapshot.Asks) != 0 {
+		t.Fatalf("expected only other's bid left, got %+v", snapshot)
+	}
+}
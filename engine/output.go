@@ -0,0 +1,316 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OutputPolicy decides what a book does with an event when a consumer's
+// buffer is full.
+type OutputPolicy int
+
+const (
+	// OutputBlock waits for the consumer, stalling matching until it reads.
+	OutputBlock OutputPolicy = iota
+	// OutputDrop discards the event and counts it in OutputStats.
+	OutputDrop
+	// OutputSpill queues the event in an unbounded buffer that is drained
+	// into the consumer's channel in order. Nothing is lost and matching
+	// never waits, at the cost of memory while the consumer is behind.
+	OutputSpill
+)
+
+// OutputStats counts events a book dropped because a consumer fell behind.
+type OutputStats struct {
+	Trades  uint64
+	Updates uint64
+	Reports uint64
+	Depth   uint64
+	L3      uint64
+}
+
+// SubscribeOptions tunes one subscriber's stream.
+type SubscribeOptions struct {
+	// Buffer is the channel capacity; zero uses the stream's default.
+	Buffer int
+	// Policy applies when the buffer is full. It affects trades and execution
+	// reports; book updates supersede one another and are always dropped when
+	// unread.
+	Policy OutputPolicy
+	// OrderIDs, if set, limits the stream to events touching an order it
+	// reports as owned: either side of a trade, the order of a report, or the
+	// best bid or ask of a book update. It runs on the matching goroutine and must be quick.
+	OrderIDs func(id string) bool
+	// Owner, if set, limits execution reports to that owner's orders. Other
+	// streams ignore it.
+	Owner string
+}
+
+// Subscription is one consumer's copy of a book stream.
+type Subscription[T any] struct {
+	// C receives the events. It is closed after Unsubscribe, or once the
+	// book stops and every queued event has been delivered.
+	C      <-chan T
+	feed   *fanout[T]
+	outlet *outlet[T]
+}
+
+// Dropped returns how many events this subscription has lost because its
+// buffer was full under OutputDrop.
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.outlet.lost.Load()
+}
+
+// Unsubscribe stops delivery and closes C. Events still buffered are
+// discarded. It is safe to call more than once.
+func (s *Subscription[T]) Unsubscribe() {
+	s.feed.remove(s.outlet)
+	s.outlet.stop()
+}
+
+// fanout delivers every published event to each of its subscribers, each
+// with its own buffer, so consumers never compete for events.
+type fanout[T any] struct {
+	mu      sync.Mutex
+	outlets atomic.Pointer[[]*outlet[T]]
+	buffer  int
+	policy  OutputPolicy
+	dropped atomic.Uint64
+	closed  bool
+}
+
+func newFanout[T any](buffer int, policy OutputPolicy) *fanout[T] {
+	f := &fanout[T]{buffer: buffer, policy: policy}
+	f.outlets.Store(&[]*outlet[T]{})
+	return f
+}
+
+// subscribe adds a consumer with the fanout's own buffer and policy.
+func (f *fanout[T]) subscribe() <-chan T {
+	return f.subscribeWith(f.buffer, f.policy, nil).C
+}
+
+// subscribeWith adds a consumer. Subscribing to a closed fanout returns a
+// closed channel.
+func (f *fanout[T]) subscribeWith(buffer int, policy OutputPolicy, filter func(T) bool) *Subscription[T] {
+	if buffer <= 0 {
+		buffer = f.buffer
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o := newOutlet[T](buffer, policy, filter, &f.dropped)
+	sub := &Subscription[T]{C: o.ch, feed: f, outlet: o}
+	if f.closed {
+		o.close()
+		return sub
+	}
+	current := *f.outlets.Load()
+	next := make([]*outlet[T], len(current), len(current)+1)
+	copy(next, current)
+	next = append(next, o)
+	f.outlets.Store(&next)
+	return sub
+}
+
+func (f *fanout[T]) remove(o *outlet[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := *f.outlets.Load()
+	next := make([]*outlet[T], 0, len(current))
+	for _, existing := range current {
+		if existing != o {
+			next = append(next, existing)
+		}
+	}
+	f.outlets.Store(&next)
+}
+
+func (f *fanout[T]) publish(v T) {
+	for _, o := range *f.outlets.Load() {
+		o.send(v)
+	}
+}
+
+func (f *fanout[T]) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	for _, o := range *f.outlets.Load() {
+		o.close()
+	}
+}
+
+// outlet is one subscriber's channel and the policy for feeding it.
+type outlet[T any] struct {
+	ch      chan T
+	policy  OutputPolicy
+	filter  func(T) bool
+	dropped *atomic.Uint64 // shared by the fanout's outlets
+	lost    atomic.Uint64  // this outlet's own drops
+
+	// done is closed on Unsubscribe to release a blocked send. Senders hold
+	// sendMu for reading and check chClosed, which is set under the write
+	// lock, so they never send on ch once it is closed.
+	done      chan struct{}
+	sendMu    sync.RWMutex
+	chClosed  bool
+	stopOnce  sync.Once
+	closeOnce sync.Once
+
+	// Spill state: events queue in spill and a pump goroutine moves them
+	// into ch.
+	mu     sync.Mutex
+	spill  ring[T]
+	wake   chan struct{}
+	closed bool
+}
+
+func newOutlet[T any](buffer int, policy OutputPolicy, filter func(T) bool, dropped *atomic.Uint64) *outlet[T] {
+	o := &outlet[T]{
+		ch:      make(chan T, buffer),
+		policy:  policy,
+		filter:  filter,
+		dropped: dropped,
+		done:    make(chan struct{}),
+	}
+	if policy == OutputSpill {
+		o.wake = make(chan struct{}, 1)
+		go o.pump()
+	}
+	return o
+}
+
+func (o *outlet[T]) send(v T) {
+	if o.filter != nil && !o.filter(v) {
+		return
+	}
+	switch o.policy {
+	case OutputDrop:
+		o.sendMu.RLock()
+		defer o.sendMu.RUnlock()
+		if o.chClosed {
+			return
+		}
+		select {
+		case <-o.done:
+		case o.ch <- v:
+		default:
+			o.dropped.Add(1)
+			o.lost.Add(1)
+		}
+	case OutputSpill:
+		o.mu.Lock()
+		o.spill.push(v)
+		o.mu.Unlock()
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	default:
+		o.sendMu.RLock()
+		defer o.sendMu.RUnlock()
+		if o.chClosed {
+			return
+		}
+		select {
+		case <-o.done:
+		case o.ch <- v:
+		}
+	}
+}
+
+// pump drains the spill buffer into the channel, closing it once the outlet
+// is closed and everything queued has been delivered, or on Unsubscribe.
+func (o *outlet[T]) pump() {
+	defer close(o.ch)
+	for {
+		select {
+		case <-o.wake:
+		case <-o.done:
+			return
+		}
+		for {
+			o.mu.Lock()
+			v, ok := o.spill.pop()
+			closed := o.closed
+			o.mu.Unlock()
+			if !ok {
+				if closed {
+					return
+				}
+				break
+			}
+			select {
+			case o.ch <- v:
+			case <-o.done:
+				return
+			}
+		}
+	}
+}
+
+// close ends the stream once pending events are delivered.
+func (o *outlet[T]) close() {
+	if o.policy != OutputSpill {
+		o.closeChannel()
+		return
+	}
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop ends the stream immediately, discarding pending events.
+func (o *outlet[T]) stop() {
+	o.stopOnce.Do(func() { close(o.done) })
+	if o.policy != OutputSpill {
+		o.closeChannel()
+	}
+}
+
+func (o *outlet[T]) closeChannel() {
+	o.closeOnce.Do(func() {
+		o.sendMu.Lock()
+		o.chClosed = true
+		close(o.ch)
+		o.sendMu.Unlock()
+	})
+}
+
+// ring is a growable FIFO ring buffer.
+type ring[T any] struct {
+	buf  []T
+	head int
+	n    int
+}
+
+func (r *ring[T]) push(v T) {
+	if r.n == len(r.buf) {
+		grown := make([]T, max(16, 2*len(r.buf)))
+		for i := 0; i < r.n; i++ {
+			grown[i] = r.buf[(r.head+i)%len(r.buf)]
+		}
+		r.buf, r.head = grown, 0
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = v
+	r.n++
+}
+
+func (r *ring[T]) pop() (T, bool) {
+	var zero T
+	if r.n == 0 {
+		return zero, false
+	}
+	v := r.buf[r.head]
+	r.buf[r.head] = zero
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+	return v, true
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// crossOrders rests n single-lot asks and lifts each with a bid, producing n
+// trades.
+func crossOrders(t *testing.T, ob *OrderBook, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		ask := Order{ID: fmt.Sprintf("ask%d", i), Symbol: "OUT", Side: Sell, Type: Limit, Price: 100, Quantity: 1}
+		bid := Order{ID: fmt.Sprintf("bid%d", i), Symbol: "OUT", Side: Buy, Type: Limit, Price: 100, Quantity: 1}
+		if err := ob.SubmitOrder(ask); err != nil {
+			t.Fatalf("submit %s: %v", ask.ID, err)
+		}
+		if err := ob.SubmitOrder(bid); err != nil {
+			t.Fatalf("submit %s: %v", bid.ID, err)
+		}
+	}
+}
+
+func TestDropPolicyCountsUnreadTrades(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "OUT", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Unix(0, 0)), TradePolicy: OutputDrop})
+	defer ob.Stop()
+	small := ob.SubscribeTrades(SubscribeOptions{Buffer: 1000, Policy: OutputDrop})
+
+	crossOrders(t, ob, 1100)
+
+	if got := ob.OutputStats().Trades; got != 1100-1024+1100-1000 {
+		t.Fatalf("expected %d dropped trades, got %d", 1100-1024+1100-1000, got)
+	}
+	if got := small.Dropped(); got != 1100-1000 {
+		t.Fatalf("expected the subscriber to count only its own %d drops, got %d", 1100-1000, got)
+	}
+	if first := <-ob.Trades(); first.BuyOrderID != "bid0" {
+		t.Fatalf("expected the oldest trade to be kept, got %+v", first)
+	}
+}
+
+func TestSpillPolicyKeepsEveryTradeInOrder(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "OUT", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Unix(0, 0)), TradePolicy: OutputSpill})
+
+	other := ob.SubscribeTrades(SubscribeOptions{Policy: OutputSpill}).C
+	crossOrders(t, ob, 3000)
+	ob.Stop()
+
+	for name, trades := range map[string]<-chan MatchResult{"Trades": ob.Trades(), "subscriber": other} {
+		n := 0
+		for trade := range trades {
+			if want := fmt.Sprintf("bid%d", n); trade.BuyOrderID != want {
+				t.Fatalf("%s: trade %d is %s, want %s", name, n, trade.BuyOrderID, want)
+			}
+			n++
+		}
+		if n != 3000 {
+			t.Fatalf("%s: expected 3000 trades, got %d", name, n)
+		}
+	}
+	if stats := ob.OutputStats(); stats.Trades != 0 {
+		t.Fatalf("spill should not drop, got %+v", stats)
+	}
+}
+
+func TestSubscribersFilterByOwnershipAndUnsubscribe(t *testing.T) {
+	ob := NewOrderBook(OrderBookConfig{Symbol: "OUT", TickSize: 1, MaxDepth: 10, Inline: true,
+		Clock: NewSimClock(time.Unix(0, 0))})
+	defer ob.Stop()
+
+	all := ob.SubscribeTrades(SubscribeOptions{Buffer: 8})
+	mine := ob.SubscribeTrades(SubscribeOptions{OrderIDs: func(id string) bool { return id == "bid1" }})
+	gone := ob.SubscribeTrades(SubscribeOptions{Buffer: 1})
+	gone.Unsubscribe()
+	gone.Unsubscribe()
+
+	// gone is never read; with the block policy it would stall matching if
+	// it were still subscribed.
+	crossOrders(t, ob, 3)
+
+	if got := len(all.C); got != 3 {
+		t.Fatalf("expected 3 trades for the unfiltered subscriber, got %d", got)
+	}
+	if got := len(ob.Trades()); got != 3 {
+		t.Fatalf("expected Trades to still see 3 trades, got %d", got)
+	}
+	if got := len(mine.C); got != 1 {
+		t.Fatalf("expected only the owned trade, got %d", got)
+	}
+	if trade := <-mine.C; trade.BuyOrderID != "bid1" {
+		t.Fatalf("unexpected owned trade %+v", trade)
+	}
+	if _, ok := <-gone.C; ok {
+		t.Fatalf("expected unsubscribed channel to be closed")
+	}
+}
+
+// TestUnsubscribeRacesPublishers churns subscriptions while several
+// goroutines publish, so a send can land just as its outlet closes. Run
+// with -race.
+func TestUnsubscribeRacesPublishers(t *testing.T) {
+	for _, policy := range []OutputPolicy{OutputBlock, OutputDrop, OutputSpill} {
+		feed := newFanout[int](4, policy)
+		// Unread subscribers ahead of the churned one widen the gap between
+		// a publisher loading the outlets and reaching the last of them.
+		for i := 0; i < 16; i++ {
+			feed.subscribeWith(1, OutputDrop, nil)
+		}
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for p := 0; p < 4; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						feed.publish(i)
+					}
+				}
+			}()
+		}
+		for i := 0; i < 5000; i++ {
+			sub := feed.subscribeWith(1, policy, nil)
+			if i%2 == 0 {
+				select {
+				case <-sub.C:
+				default:
+				}
+			}
+			sub.Unsubscribe()
+		}
+		close(stop)
+		wg.Wait()
+		feed.close()
+	}
+}
@@ -1,104 +1,263 @@
 package engine
 
-import "container/heap"
+import "time"
 
-// orderEntry wraps an order for heap operations.
+// orderEntry is a resting order's handle in the book. It links the order into
+// its price level's FIFO, so it can be unlinked in O(1). visible tracks the
+// displayed slice of an iceberg order; for ordinary orders it always equals
+// Remaining.
 type orderEntry struct {
-	order *Order
-	index int
-	isBid bool
+	order   *Order
+	isBid   bool
+	visible int64
+	level   *priceLevel
+	prev    *orderEntry
+	next    *orderEntry
 }
 
-// priceTimeQueue implements a price-time priority queue.
-type priceTimeQueue []*orderEntry
+// displaySlice returns how much of the order's remaining quantity to show.
+func displaySlice(order *Order) int64 {
+	if order.DisplayQuantity > 0 && order.DisplayQuantity < order.Remaining {
+		return order.DisplayQuantity
+	}
+	return order.Remaining
+}
 
-func (q priceTimeQueue) Len() int { return len(q) }
+// priceLevel holds the orders resting at one price in time priority. Orders
+// only ever join at the tail with a fresh Sequence, so the list is also in
+// Sequence order. quantity and orders aggregate its entries for depth.
+type priceLevel struct {
+	price    int64
+	quantity int64
+	orders   int
+	head     *orderEntry
+	tail     *orderEntry
+	better   *priceLevel
+	worse    *priceLevel
+}
 
-func (q priceTimeQueue) Less(i, j int) bool {
-	// For bids: higher price has priority, then older timestamp/sequence.
-	// For asks: lower price has priority, then older timestamp/sequence.
-	a, b := q[i], q[j]
-	if a.order.Price != b.order.Price {
-		if a.isBid {
-			return a.order.Price > b.order.Price
-		}
-		return a.order.Price < b.order.Price
-	}
-	if !a.order.Timestamp.Equal(b.order.Timestamp) {
-		return a.order.Timestamp.Before(b.order.Timestamp)
+// bookSide is one side of the book: price levels linked from best to worst,
+// indexed by price. Best and worst orders, appends and removals are O(1);
+// opening a new level walks from whichever end is nearer its price. dirty
+// holds the prices whose level changed since the last depth update.
+type bookSide struct {
+	isBid  bool
+	levels map[int64]*priceLevel
+	best   *priceLevel
+	worst  *priceLevel
+	count  int
+	free   *priceLevel
+	dirty  map[int64]struct{}
+}
+
+func newBookSide(isBid bool) bookSide {
+	return bookSide{isBid: isBid, levels: make(map[int64]*priceLevel), dirty: make(map[int64]struct{})}
+}
+
+// Len returns the number of resting orders on the side.
+func (s *bookSide) Len() int { return s.count }
+
+// peek returns the order with the highest priority, or nil.
+func (s *bookSide) peek() *orderEntry {
+	if s.best == nil {
+		return nil
 	}
-	return a.order.Sequence < b.order.Sequence
+	return s.best.head
 }
 
-func (q priceTimeQueue) Swap(i, j int) {
-	q[i], q[j] = q[j], q[i]
-	q[i].index = i
-	q[j].index = j
+// worstEntry returns the order with the lowest priority, or nil.
+func (s *bookSide) worstEntry() *orderEntry {
+	if s.worst == nil {
+		return nil
+	}
+	return s.worst.tail
 }
 
-func (q *priceTimeQueue) Push(x any) {
-	entry := x.(*orderEntry)
-	entry.index = len(*q)
-	*q = append(*q, entry)
+// outranks reports whether price a is better than price b on this side.
+func (s *bookSide) outranks(a, b int64) bool {
+	if s.isBid {
+		return a > b
+	}
+	return a < b
 }
 
-func (q *priceTimeQueue) Pop() any {
-	old := *q
-	n := len(old)
-	entry := old[n-1]
-	entry.index = -1
-	*q = old[0 : n-1]
-	return entry
+// push appends an entry to the back of the queue at its price.
+func (s *bookSide) push(entry *orderEntry) {
+	level := s.levels[entry.order.Price]
+	if level == nil {
+		level = s.openLevel(entry.order.Price)
+	}
+	entry.level = level
+	entry.prev = level.tail
+	entry.next = nil
+	if level.tail != nil {
+		level.tail.next = entry
+	} else {
+		level.head = entry
+	}
+	level.tail = entry
+	level.quantity += entry.visible
+	level.orders++
+	s.dirty[level.price] = struct{}{}
+	s.count++
 }
 
-func (q priceTimeQueue) peek() *orderEntry {
-	if len(q) == 0 {
-		return nil
+// remove unlinks an entry, closing its level if it was the last order there.
+func (s *bookSide) remove(entry *orderEntry) {
+	level := entry.level
+	if entry.prev != nil {
+		entry.prev.next = entry.next
+	} else {
+		level.head = entry.next
+	}
+	if entry.next != nil {
+		entry.next.prev = entry.prev
+	} else {
+		level.tail = entry.prev
+	}
+	entry.level, entry.prev, entry.next = nil, nil, nil
+	level.quantity -= entry.visible
+	level.orders--
+	s.dirty[level.price] = struct{}{}
+	s.count--
+	if level.head == nil {
+		s.closeLevel(level)
 	}
-	return q[0]
 }
 
-func (q *priceTimeQueue) remove(entry *orderEntry) *orderEntry {
-	return heap.Remove(q, entry.index).(*orderEntry)
+// setVisible changes the displayed slice of a resting entry.
+func (s *bookSide) setVisible(entry *orderEntry, visible int64) {
+	entry.level.quantity += visible - entry.visible
+	entry.visible = visible
+	s.dirty[entry.level.price] = struct{}{}
 }
 
-func (q *priceTimeQueue) findWorstIndex(isBid bool) int {
-	if len(*q) == 0 {
-		return -1
+// requeue moves an entry to the back of its level, as if it had just arrived.
+func (s *bookSide) requeue(entry *orderEntry) {
+	if entry.level.tail == entry {
+		return
 	}
-	worstIdx := 0
-	for i := range *q {
-		if isBid {
-			if (*q)[i].order.Price < (*q)[worstIdx].order.Price {
-				worstIdx = i
-			} else if (*q)[i].order.Price == (*q)[worstIdx].order.Price {
-				if (*q)[i].order.Timestamp.After((*q)[worstIdx].order.Timestamp) {
-					worstIdx = i
-				}
-			}
-		} else {
-			if (*q)[i].order.Price > (*q)[worstIdx].order.Price {
-				worstIdx = i
-			} else if (*q)[i].order.Price == (*q)[worstIdx].order.Price {
-				if (*q)[i].order.Timestamp.After((*q)[worstIdx].order.Timestamp) {
-					worstIdx = i
-				}
+	s.remove(entry)
+	s.push(entry)
+}
+
+// each visits orders in priority order until fn returns false.
+func (s *bookSide) each(fn func(*orderEntry) bool) {
+	for level := s.best; level != nil; level = level.worse {
+		for entry := level.head; entry != nil; entry = entry.next {
+			if !fn(entry) {
+				return
 			}
 		}
 	}
-	return worstIdx
 }
 
-func trimDepth(q *priceTimeQueue, maxDepth int, isBid bool, orderIndex map[string]*orderEntry, release func(*orderEntry)) {
-	for maxDepth > 0 && q.Len() > maxDepth {
-		idx := q.findWorstIndex(isBid)
-		if idx < 0 {
-			return
+func (s *bookSide) openLevel(price int64) *priceLevel {
+	level := s.free
+	if level != nil {
+		s.free = level.worse
+		*level = priceLevel{}
+	} else {
+		level = &priceLevel{}
+	}
+	level.price = price
+	s.levels[price] = level
+
+	// Find the first level the new price outranks, walking from the end
+	// nearer to it; new levels usually open close to the touch.
+	var next *priceLevel
+	if s.best != nil && absPrice(price-s.best.price) <= absPrice(price-s.worst.price) {
+		next = s.best
+		for next != nil && !s.outranks(price, next.price) {
+			next = next.worse
 		}
-		entry := heap.Remove(q, idx).(*orderEntry)
+	} else {
+		prev := s.worst
+		for prev != nil && s.outranks(price, prev.price) {
+			prev = prev.better
+		}
+		if prev != nil {
+			next = prev.worse
+		} else {
+			next = s.best
+		}
+	}
+
+	level.worse = next
+	if next != nil {
+		level.better = next.better
+		next.better = level
+	} else {
+		level.better = s.worst
+		s.worst = level
+	}
+	if level.better != nil {
+		level.better.worse = level
+	} else {
+		s.best = level
+	}
+	return level
+}
+
+func (s *bookSide) closeLevel(level *priceLevel) {
+	if level.better != nil {
+		level.better.worse = level.worse
+	} else {
+		s.best = level.worse
+	}
+	if level.worse != nil {
+		level.worse.better = level.better
+	} else {
+		s.worst = level.better
+	}
+	delete(s.levels, level.price)
+	*level = priceLevel{worse: s.free}
+	s.free = level
+}
+
+func absPrice(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// trimDepth evicts the lowest-priority orders until the side holds at most
+// maxDepth of them.
+func trimDepth(side *bookSide, maxDepth int, orderIndex map[string]*orderEntry, evict func(*orderEntry)) {
+	for maxDepth > 0 && side.Len() > maxDepth {
+		entry := side.worstEntry()
+		side.remove(entry)
 		delete(orderIndex, entry.order.ID)
-		if release != nil {
-			release(entry)
+		if evict != nil {
+			evict(entry)
 		}
 	}
 }
+
+// expiryItem records when a resting order becomes eligible for expiry.
+type expiryItem struct {
+	at time.Time
+	id string
+}
+
+// expiryQueue orders expiring orders by deadline. Items are removed lazily:
+// canceled or filled orders stay queued until they reach the top and are
+// discarded once the book no longer holds a matching order.
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int { return len(q) }
+
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q expiryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x any) { *q = append(*q, x.(expiryItem)) }
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[0 : n-1]
+	return item
+}
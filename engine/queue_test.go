@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBookSideKeepsPriceThenSequenceOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for _, isBid := range []bool{true, false} {
+		side := newBookSide(isBid)
+		var live []*orderEntry
+		for seq := int64(1); seq <= 2000; seq++ {
+			if len(live) > 0 && rng.Intn(3) == 0 {
+				i := rng.Intn(len(live))
+				side.remove(live[i])
+				live = append(live[:i], live[i+1:]...)
+				continue
+			}
+			entry := &orderEntry{order: &Order{Price: 100 + rng.Int63n(40), Sequence: seq}, isBid: isBid, visible: 1 + rng.Int63n(5)}
+			side.push(entry)
+			live = append(live, entry)
+		}
+
+		if side.Len() != len(live) {
+			t.Fatalf("expected %d orders, got %d", len(live), side.Len())
+		}
+		var prev *orderEntry
+		seen := 0
+		side.each(func(entry *orderEntry) bool {
+			if prev != nil {
+				better := side.outranks(prev.order.Price, entry.order.Price)
+				samePrice := prev.order.Price == entry.order.Price
+				if !better && !(samePrice && prev.order.Sequence < entry.order.Sequence) {
+					t.Fatalf("order %+v ranked ahead of %+v", *prev.order, *entry.order)
+				}
+			}
+			prev = entry
+			seen++
+			return true
+		})
+		if seen != len(live) || side.worstEntry() != prev {
+			t.Fatalf("walked %d of %d orders; worst entry mismatch", seen, len(live))
+		}
+		if len(side.levels) == 0 || side.peek().level != side.best {
+			t.Fatalf("best level out of sync")
+		}
+
+		want := make(map[int64]PriceLevel)
+		for _, entry := range live {
+			level := want[entry.order.Price]
+			level.Price = entry.order.Price
+			level.Quantity += entry.visible
+			level.Orders++
+			want[entry.order.Price] = level
+		}
+		levels := side.top(0)
+		if len(levels) != len(want) {
+			t.Fatalf("expected %d depth levels, got %d", len(want), len(levels))
+		}
+		for i, level := range levels {
+			if level != want[level.Price] || i > 0 && !side.outranks(levels[i-1].Price, level.Price) {
+				t.Fatalf("depth level %d: expected %+v in price order, got %+v", i, want[level.Price], level)
+			}
+		}
+	}
+}
@@ -0,0 +1,685 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrderRouter accepts order entry for one or more books. Exchange, Accounts
+// and RiskGateway all implement it, so they can be stacked.
+type OrderRouter interface {
+	SubmitOrder(order Order) error
+	SubmitOrders(orders []Order) []error
+	CancelOrder(symbol, id string) error
+	CancelOrders(filter CancelFilter) ([]Order, error)
+	AmendOrder(symbol, id string, price *int64, qty *int64) error
+	ReplaceOrder(symbol, id, newID string, price *int64, qty *int64) error
+}
+
+// Reason codes carried by RiskError.
+const (
+	RiskMaxQuantity    = "max_quantity"
+	RiskMaxNotional    = "max_notional"
+	RiskPriceCollar    = "price_collar"
+	RiskMaxOpenOrders  = "max_open_orders"
+	RiskMaxPosition    = "max_position"
+	RiskRateLimit      = "rate_limit"
+	RiskAccountBlocked = "account_blocked"
+	RiskOwnerRequired  = "owner_required"
+)
+
+// RiskError is a pre-trade rejection. Code is one of the Risk* constants.
+type RiskError struct {
+	Code   string
+	Reason string
+}
+
+func (e *RiskError) Error() string {
+	return e.Code + ": " + e.Reason
+}
+
+func riskError(code, format string, args ...any) error {
+	return &RiskError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// CollarReference picks the price a collar is measured from.
+type CollarReference int
+
+const (
+	// CollarLastTrade measures from the last trade, falling back to the mid
+	// before the first trade.
+	CollarLastTrade CollarReference = iota
+	// CollarMid measures from the midpoint of the best bid and ask.
+	CollarMid
+)
+
+// RiskLimits are the pre-trade checks for an account on a symbol. A zero
+// field is not checked.
+type RiskLimits struct {
+	MaxOrderQuantity int64
+	MaxNotional      int64 // price times quantity of a single order
+	// A limit price may be at most CollarTicks ticks or CollarPercent
+	// percent away from the CollarFrom reference price.
+	CollarTicks    int64
+	CollarPercent  float64
+	CollarFrom     CollarReference
+	MaxOpenOrders  int
+	MaxPosition    int64 // absolute position if every working order filled
+	MaxMessageRate int   // orders, cancels and amends per second
+}
+
+// RiskConfig holds limits at three levels. For an order, the Default limits
+// are overlaid by the symbol's, then by the account's: each non-zero field
+// replaces the one beneath it.
+type RiskConfig struct {
+	Default  RiskLimits
+	Symbols  map[string]RiskLimits
+	Accounts map[string]RiskLimits
+	Clock    Clock // times the message rate limit; defaults to the wall clock
+}
+
+// RiskGateway checks orders against RiskConfig before passing them on. It
+// follows each book's execution reports to track every account's working
+// orders and positions.
+type RiskGateway struct {
+	next    OrderRouter
+	ex      *Exchange
+	cfg     RiskConfig
+	clock   Clock
+	mu      sync.Mutex
+	orders  map[string]*riskOrder
+	owners  map[string]*riskAccount
+	lastPx  map[string]int64
+	blocked map[string]bool
+}
+
+type riskOrder struct {
+	owner  string
+	symbol string
+	side   Side
+	typ    OrderType
+	price  int64
+	stop   int64
+	leaves int64
+}
+
+type riskAccount struct {
+	open      int
+	positions map[string]int64
+	buying    map[string]int64 // working buy quantity per symbol
+	selling   map[string]int64
+	window    time.Time // start of the current rate-limit second
+	messages  int
+	// inFlight is held for reading from a new order's admission until it
+	// reaches its book, and for writing by Block, so no order can pass the
+	// kill switch check and land after the mass cancel.
+	inFlight sync.RWMutex
+}
+
+// NewRiskGateway checks orders for the books of ex and routes those that pass
+// to next, which is ex itself or a layer over it such as Accounts.
+func NewRiskGateway(ex *Exchange, next OrderRouter, cfg RiskConfig) *RiskGateway {
+	g := &RiskGateway{
+		next:    next,
+		ex:      ex,
+		cfg:     cfg,
+		clock:   cfg.Clock,
+		orders:  make(map[string]*riskOrder),
+		owners:  make(map[string]*riskAccount),
+		lastPx:  make(map[string]int64),
+		blocked: make(map[string]bool),
+	}
+	if g.clock == nil {
+		g.clock = systemClock{}
+	}
+	for _, inst := range ex.Instruments() {
+		book, _ := ex.Book(inst.Symbol)
+		sub := book.SubscribeExecutionReports(SubscribeOptions{Policy: OutputSpill})
+		go g.consume(sub.C)
+	}
+	return g
+}
+
+func (g *RiskGateway) consume(reports <-chan ExecutionReport) {
+	for rep := range reports {
+		g.apply(rep)
+	}
+}
+
+// Limits returns the limits that apply to account on symbol.
+func (g *RiskGateway) Limits(account, symbol string) RiskLimits {
+	limits := g.cfg.Default
+	limits.overlay(g.cfg.Symbols[symbol])
+	limits.overlay(g.cfg.Accounts[account])
+	return limits
+}
+
+func (l *RiskLimits) overlay(o RiskLimits) {
+	if o.MaxOrderQuantity != 0 {
+		l.MaxOrderQuantity = o.MaxOrderQuantity
+	}
+	if o.MaxNotional != 0 {
+		l.MaxNotional = o.MaxNotional
+	}
+	if o.CollarTicks != 0 || o.CollarPercent != 0 {
+		l.CollarTicks, l.CollarPercent, l.CollarFrom = o.CollarTicks, o.CollarPercent, o.CollarFrom
+	}
+	if o.MaxOpenOrders != 0 {
+		l.MaxOpenOrders = o.MaxOpenOrders
+	}
+	if o.MaxPosition != 0 {
+		l.MaxPosition = o.MaxPosition
+	}
+	if o.MaxMessageRate != 0 {
+		l.MaxMessageRate = o.MaxMessageRate
+	}
+}
+
+// SubmitOrder checks an order and routes it on. Orders must name an Owner.
+func (g *RiskGateway) SubmitOrder(order Order) error {
+	unlock := g.admitting([]Order{order})
+	defer unlock()
+	ro, err := g.reserve(order)
+	if err != nil {
+		return err
+	}
+	if err := g.next.SubmitOrder(order); err != nil {
+		g.release(order.ID, ro)
+		return err
+	}
+	return nil
+}
+
+// SubmitOrders checks each order of a batch in turn, as SubmitOrder does, and
+// routes the ones that pass on together. Every order counts as a message.
+func (g *RiskGateway) SubmitOrders(orders []Order) []error {
+	unlock := g.admitting(orders)
+	defer unlock()
+	errs := make([]error, len(orders))
+	var passed []Order
+	var indexes []int
+	var reserved []*riskOrder
+	for i, order := range orders {
+		ro, err := g.reserve(order)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		passed = append(passed, order)
+		indexes = append(indexes, i)
+		reserved = append(reserved, ro)
+	}
+	for j, err := range g.next.SubmitOrders(passed) {
+		if err != nil {
+			g.release(passed[j].ID, reserved[j])
+			errs[indexes[j]] = err
+		}
+	}
+	return errs
+}
+
+// admitting holds off Block for the owners of orders until the returned
+// function is called. Owners are taken in order so that batches sharing them
+// cannot deadlock against two Blocks.
+func (g *RiskGateway) admitting(orders []Order) func() {
+	owners := make([]string, 0, len(orders))
+	for _, order := range orders {
+		owners = append(owners, order.Owner)
+	}
+	sort.Strings(owners)
+	var held []*riskAccount
+	for i, owner := range owners {
+		if owner == "" || i > 0 && owner == owners[i-1] {
+			continue
+		}
+		g.mu.Lock()
+		acct := g.account(owner)
+		g.mu.Unlock()
+		acct.inFlight.RLock()
+		held = append(held, acct)
+	}
+	return func() {
+		for _, acct := range held {
+			acct.inFlight.RUnlock()
+		}
+	}
+}
+
+// reserve checks a new order and counts it against its owner's limits until
+// it finishes.
+func (g *RiskGateway) reserve(order Order) (*riskOrder, error) {
+	if order.Owner == "" {
+		return nil, riskError(RiskOwnerRequired, "order owner is required")
+	}
+	book, err := g.ex.Book(order.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	limits := g.Limits(order.Owner, order.Symbol)
+
+	// The reference price needs the book, so fetch it before taking the lock.
+	var ref int64
+	if order.Price == 0 || limits.CollarTicks != 0 || limits.CollarPercent != 0 {
+		ref = g.reference(book, limits.CollarFrom)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	acct := g.account(order.Owner)
+	if err := g.admit(acct, order.Owner, limits); err != nil {
+		return nil, err
+	}
+	if err := g.check(acct, &order, limits, book.cfg.TickSize, ref); err != nil {
+		return nil, err
+	}
+	if _, working := g.orders[order.ID]; working {
+		return nil, orderError(OrderDuplicateID, "order %s is already working", order.ID)
+	}
+	ro := &riskOrder{owner: order.Owner, symbol: order.Symbol, side: order.Side, typ: order.Type, price: order.Price, stop: order.StopPrice}
+	g.orders[order.ID] = ro
+	acct.open++
+	acct.resize(ro, order.Quantity)
+	return ro, nil
+}
+
+// release forgets an order its book turned away.
+func (g *RiskGateway) release(id string, ro *riskOrder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.orders[id] == ro {
+		g.close(id, ro)
+	}
+}
+
+// CancelOrder routes a cancel on, counting it against the owner's message
+// rate. Cancels are allowed for blocked accounts.
+func (g *RiskGateway) CancelOrder(symbol, id string) error {
+	g.mu.Lock()
+	if ro, ok := g.orders[id]; ok {
+		limits := g.Limits(ro.owner, symbol)
+		if err := g.throttle(g.account(ro.owner), limits); err != nil {
+			g.mu.Unlock()
+			return err
+		}
+	}
+	g.mu.Unlock()
+	return g.next.CancelOrder(symbol, id)
+}
+
+// CancelOrders routes a mass cancel on. One for a single owner counts as one
+// message against its rate; like cancels, it is allowed for blocked accounts.
+func (g *RiskGateway) CancelOrders(filter CancelFilter) ([]Order, error) {
+	if filter.Owner != "" {
+		g.mu.Lock()
+		err := g.throttle(g.account(filter.Owner), g.Limits(filter.Owner, filter.Symbol))
+		g.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g.next.CancelOrders(filter)
+}
+
+// AmendOrder checks the amended price and quantity and routes the amend on.
+func (g *RiskGateway) AmendOrder(symbol, id string, price *int64, qty *int64) error {
+	book, err := g.ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	ro, tracked, err := g.lookup(book, id)
+	if err != nil {
+		return err
+	}
+	// The amended quantity includes what has already filled.
+	leaves := ro.leaves
+	if qty != nil {
+		if status, err := book.OrderStatus(id); err == nil && *qty > status.CumQty {
+			leaves = *qty - status.CumQty
+		}
+	}
+
+	limits := g.Limits(ro.owner, symbol)
+	var ref int64
+	if price != nil && (limits.CollarTicks != 0 || limits.CollarPercent != 0) {
+		ref = g.reference(book, limits.CollarFrom)
+	}
+
+	g.mu.Lock()
+	acct := g.account(ro.owner)
+	if err := g.admit(acct, ro.owner, limits); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	if qty != nil && limits.MaxOrderQuantity > 0 && *qty > limits.MaxOrderQuantity {
+		g.mu.Unlock()
+		return riskError(RiskMaxQuantity, "quantity %d exceeds %d", *qty, limits.MaxOrderQuantity)
+	}
+	if price != nil {
+		if err := checkCollar(*price, ref, limits, book.cfg.TickSize); err != nil {
+			g.mu.Unlock()
+			return err
+		}
+		if limits.MaxNotional > 0 && *price*leaves > limits.MaxNotional {
+			g.mu.Unlock()
+			return riskError(RiskMaxNotional, "notional %d exceeds %d", *price*leaves, limits.MaxNotional)
+		}
+	}
+	// A tracked order already counts toward the position; only growth is new.
+	extra := leaves
+	if tracked != nil {
+		extra -= ro.leaves
+	}
+	if limits.MaxPosition > 0 && extra > 0 {
+		position := acct.positions[symbol]
+		worst := position + acct.buying[symbol] + extra
+		if ro.side == Sell {
+			worst = -(position - acct.selling[symbol] - extra)
+		}
+		if worst > limits.MaxPosition {
+			g.mu.Unlock()
+			return riskError(RiskMaxPosition, "position could reach %d, limit %d", worst, limits.MaxPosition)
+		}
+	}
+	g.mu.Unlock()
+	return g.next.AmendOrder(symbol, id, price, qty)
+}
+
+// ReplaceOrder checks the replacement as a new order, as though the original
+// were already gone, and routes the cancel-replace on.
+func (g *RiskGateway) ReplaceOrder(symbol, id, newID string, price *int64, qty *int64) error {
+	book, err := g.ex.Book(symbol)
+	if err != nil {
+		return err
+	}
+	ro, tracked, err := g.lookup(book, id)
+	if err != nil {
+		return err
+	}
+	replacement := Order{ID: newID, Symbol: symbol, Side: ro.side, Type: ro.typ, Price: ro.price, StopPrice: ro.stop, Quantity: ro.leaves, Owner: ro.owner}
+	if price != nil {
+		replacement.Price = *price
+	}
+	if qty != nil {
+		replacement.Quantity = *qty
+	}
+
+	limits := g.Limits(ro.owner, symbol)
+	var ref int64
+	if replacement.Price == 0 || limits.CollarTicks != 0 || limits.CollarPercent != 0 {
+		ref = g.reference(book, limits.CollarFrom)
+	}
+
+	g.mu.Lock()
+	acct := g.account(ro.owner)
+	if err := g.admit(acct, ro.owner, limits); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	if tracked != nil {
+		leaves := tracked.leaves
+		acct.open--
+		acct.resize(tracked, 0)
+		err = g.check(acct, &replacement, limits, book.cfg.TickSize, ref)
+		acct.open++
+		acct.resize(tracked, leaves)
+	} else {
+		err = g.check(acct, &replacement, limits, book.cfg.TickSize, ref)
+	}
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	if _, working := g.orders[newID]; working {
+		g.mu.Unlock()
+		return fmt.Errorf("order %s is already working", newID)
+	}
+	// The original stops counting once its cancel is reported.
+	nro := &riskOrder{owner: ro.owner, symbol: symbol, side: ro.side, typ: ro.typ, price: replacement.Price, stop: ro.stop}
+	g.orders[newID] = nro
+	acct.open++
+	acct.resize(nro, replacement.Quantity)
+	g.mu.Unlock()
+
+	if err := g.next.ReplaceOrder(symbol, id, newID, price, qty); err != nil {
+		g.mu.Lock()
+		if g.orders[newID] == nro {
+			g.close(newID, nro)
+		}
+		g.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// lookup returns a copy of a working order as the gateway sees it, and the
+// tracked original, if any. An order the gateway is not tracking, such as one
+// recovered from the journal, is read from its book so that amends and
+// replaces of it get the same checks.
+func (g *RiskGateway) lookup(book *OrderBook, id string) (riskOrder, *riskOrder, error) {
+	g.mu.Lock()
+	tracked, ok := g.orders[id]
+	var ro riskOrder
+	if ok {
+		ro = *tracked
+	}
+	g.mu.Unlock()
+	if ok {
+		return ro, tracked, nil
+	}
+	status, err := book.OrderStatus(id)
+	if err != nil {
+		return ro, nil, err
+	}
+	o := status.Order
+	return riskOrder{owner: o.Owner, symbol: o.Symbol, side: o.Side, typ: o.Type, price: o.Price, stop: o.StopPrice, leaves: status.LeavesQty}, nil, nil
+}
+
+// Block is the kill switch: it rejects every new order and amend from the
+// account and mass cancels all of its orders on every book, including ones
+// the gateway is not tracking, such as orders recovered from a journal. It
+// returns how many orders were canceled. Orders already past the check
+// reach their books first, so the mass cancel catches them too.
+func (g *RiskGateway) Block(account string) int {
+	g.mu.Lock()
+	acct := g.account(account)
+	g.mu.Unlock()
+	acct.inFlight.Lock()
+	g.mu.Lock()
+	g.blocked[account] = true
+	g.mu.Unlock()
+	acct.inFlight.Unlock()
+
+	canceled, _ := g.next.CancelOrders(CancelFilter{Owner: account})
+	return len(canceled)
+}
+
+// Unblock lifts the kill switch for an account.
+func (g *RiskGateway) Unblock(account string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.blocked, account)
+}
+
+// Blocked reports whether the kill switch is on for an account.
+func (g *RiskGateway) Blocked(account string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.blocked[account]
+}
+
+// OpenOrders returns how many orders the account has working.
+func (g *RiskGateway) OpenOrders(account string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.account(account).open
+}
+
+// admit applies the kill switch and the message rate limit.
+func (g *RiskGateway) admit(acct *riskAccount, owner string, limits RiskLimits) error {
+	if g.blocked[owner] {
+		return riskError(RiskAccountBlocked, "account %s is blocked", owner)
+	}
+	return g.throttle(acct, limits)
+}
+
+func (g *RiskGateway) throttle(acct *riskAccount, limits RiskLimits) error {
+	if limits.MaxMessageRate <= 0 {
+		return nil
+	}
+	now := g.clock.Now()
+	if now.Sub(acct.window) >= time.Second {
+		acct.window, acct.messages = now, 0
+	}
+	if acct.messages >= limits.MaxMessageRate {
+		return riskError(RiskRateLimit, "more than %d messages per second", limits.MaxMessageRate)
+	}
+	acct.messages++
+	return nil
+}
+
+// check applies the per-order limits to a new order.
+func (g *RiskGateway) check(acct *riskAccount, order *Order, limits RiskLimits, tick, ref int64) error {
+	if limits.MaxOrderQuantity > 0 && order.Quantity > limits.MaxOrderQuantity {
+		return riskError(RiskMaxQuantity, "quantity %d exceeds %d", order.Quantity, limits.MaxOrderQuantity)
+	}
+	price := order.Price
+	if order.Type == Market || order.Type == Stop {
+		price = 0
+	}
+	if price > 0 {
+		if err := checkCollar(price, ref, limits, tick); err != nil {
+			return err
+		}
+	}
+	if limits.MaxNotional > 0 {
+		if price == 0 {
+			price = order.StopPrice
+		}
+		if price == 0 {
+			price = ref
+		}
+		if notional := price * order.Quantity; notional > limits.MaxNotional {
+			return riskError(RiskMaxNotional, "notional %d exceeds %d", notional, limits.MaxNotional)
+		}
+	}
+	if limits.MaxOpenOrders > 0 && acct.open >= limits.MaxOpenOrders {
+		return riskError(RiskMaxOpenOrders, "%d orders already working", acct.open)
+	}
+	if limits.MaxPosition > 0 {
+		position := acct.positions[order.Symbol]
+		worst := position + acct.buying[order.Symbol] + order.Quantity
+		if order.Side == Sell {
+			worst = -(position - acct.selling[order.Symbol] - order.Quantity)
+		}
+		if worst > limits.MaxPosition {
+			return riskError(RiskMaxPosition, "position could reach %d, limit %d", worst, limits.MaxPosition)
+		}
+	}
+	return nil
+}
+
+func checkCollar(price, ref int64, limits RiskLimits, tick int64) error {
+	if ref <= 0 {
+		return nil
+	}
+	distance := price - ref
+	if distance < 0 {
+		distance = -distance
+	}
+	if limits.CollarTicks > 0 && distance > limits.CollarTicks*tick {
+		return riskError(RiskPriceCollar, "price %d is more than %d ticks from %d", price, limits.CollarTicks, ref)
+	}
+	if limits.CollarPercent > 0 && float64(distance) > float64(ref)*limits.CollarPercent/100 {
+		return riskError(RiskPriceCollar, "price %d is more than %g%% from %d", price, limits.CollarPercent, ref)
+	}
+	return nil
+}
+
+// reference returns the collar reference price, or zero if there is none.
+func (g *RiskGateway) reference(book *OrderBook, from CollarReference) int64 {
+	if from == CollarLastTrade {
+		g.mu.Lock()
+		last := g.lastPx[book.cfg.Symbol]
+		g.mu.Unlock()
+		if last > 0 {
+			return last
+		}
+	}
+	view, err := book.Snapshot()
+	if err != nil {
+		return 0
+	}
+	return midPrice(view)
+}
+
+func midPrice(view BookView) int64 {
+	switch {
+	case view.BestBid != nil && view.BestAsk != nil:
+		return (view.BestBid.Price + view.BestAsk.Price) / 2
+	case view.BestBid != nil:
+		return view.BestBid.Price
+	case view.BestAsk != nil:
+		return view.BestAsk.Price
+	}
+	return 0
+}
+
+// apply follows one execution report: fills move the owner's position and
+// the last price, and finished orders stop counting as working.
+func (g *RiskGateway) apply(rep ExecutionReport) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rep.LastQty > 0 {
+		g.lastPx[rep.Symbol] = rep.LastPrice
+		if rep.Owner != "" {
+			delta := rep.LastQty
+			if rep.Side == Sell {
+				delta = -delta
+			}
+			g.account(rep.Owner).positions[rep.Symbol] += delta
+		}
+	}
+	ro, ok := g.orders[rep.OrderID]
+	if !ok || ro.owner != rep.Owner || ro.symbol != rep.Symbol {
+		return
+	}
+	switch rep.State {
+	case StateCanceled, StateRejected, StateExpired, StateFilled:
+		g.close(rep.OrderID, ro)
+	default:
+		ro.price = rep.Price
+		g.account(ro.owner).resize(ro, rep.LeavesQty)
+	}
+}
+
+func (g *RiskGateway) close(id string, ro *riskOrder) {
+	acct := g.account(ro.owner)
+	acct.resize(ro, 0)
+	acct.open--
+	delete(g.orders, id)
+}
+
+func (g *RiskGateway) account(owner string) *riskAccount {
+	acct, ok := g.owners[owner]
+	if !ok {
+		acct = &riskAccount{
+			positions: make(map[string]int64),
+			buying:    make(map[string]int64),
+			selling:   make(map[string]int64),
+		}
+		g.owners[owner] = acct
+	}
+	return acct
+}
+
+// resize moves an order's working quantity to leaves.
+func (acct *riskAccount) resize(ro *riskOrder, leaves int64) {
+	if ro.side == Buy {
+		acct.buying[ro.symbol] += leaves - ro.leaves
+	} else {
+		acct.selling[ro.symbol] += leaves - ro.leaves
+	}
+	ro.leaves = leaves
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func riskCode(err error) string {
+	var riskErr *RiskError
+	if errors.As(err, &riskErr) {
+		return riskErr.Code
+	}
+	return ""
+}
+
+func TestRiskGatewayLimits(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "RSK", TickSize: 5, MaxDepth: 10, Inline: true, Clock: clock}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+
+	gw := NewRiskGateway(ex, ex, RiskConfig{
+		Default:  RiskLimits{MaxOrderQuantity: 100, MaxOpenOrders: 3, MaxMessageRate: 5},
+		Symbols:  map[string]RiskLimits{"RSK": {MaxNotional: 5000, CollarTicks: 4, CollarFrom: CollarMid}},
+		Accounts: map[string]RiskLimits{"small": {MaxPosition: 10}},
+		Clock:    clock,
+	})
+
+	order := func(id, owner string, side Side, price, qty int64) Order {
+		return Order{ID: id, Symbol: "RSK", Side: side, Type: Limit, Price: price, Quantity: qty, Owner: owner}
+	}
+	if err := gw.SubmitOrder(order("a1", "big", Sell, 100, 10)); err != nil {
+		t.Fatalf("submit a1: %v", err)
+	}
+	if err := gw.SubmitOrder(order("b1", "big", Buy, 90, 10)); err != nil {
+		t.Fatalf("submit b1: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		order Order
+		code  string
+	}{
+		{"no owner", order("x1", "", Buy, 95, 1), RiskOwnerRequired},
+		{"quantity", order("x2", "other", Buy, 95, 101), RiskMaxQuantity},
+		{"notional", order("x3", "other", Buy, 95, 100), RiskMaxNotional},
+		{"collar", order("x4", "other", Buy, 70, 1), RiskPriceCollar},
+		{"position", order("x5", "small", Buy, 95, 11), RiskMaxPosition},
+		{"open orders", order("x6", "big", Buy, 90, 1), RiskMaxOpenOrders},
+	}
+	if err := gw.SubmitOrder(order("b2", "big", Buy, 90, 1)); err != nil {
+		t.Fatalf("submit b2: %v", err)
+	}
+	for _, tc := range cases {
+		if code := riskCode(gw.SubmitOrder(tc.order)); code != tc.code {
+			t.Fatalf("%s: expected %s, got %q", tc.name, tc.code, code)
+		}
+	}
+
+	// "big" has sent four messages this second; a cancel is the fifth.
+	if err := gw.CancelOrder("RSK", "b2"); err != nil {
+		t.Fatalf("cancel b2: %v", err)
+	}
+	if code := riskCode(gw.CancelOrder("RSK", "b1")); code != RiskRateLimit {
+		t.Fatalf("expected rate limit, got %q", code)
+	}
+	clock.Advance(time.Second)
+
+	// An order the gateway never saw, as if recovered from a journal, is
+	// canceled by the kill switch too.
+	if err := ex.SubmitOrder(order("direct", "big", Buy, 85, 1)); err != nil {
+		t.Fatalf("submit direct: %v", err)
+	}
+	if n := gw.Block("big"); n != 3 {
+		t.Fatalf("expected the kill switch to cancel 3 orders, got %d", n)
+	}
+	if code := riskCode(gw.SubmitOrder(order("b3", "big", Buy, 90, 1))); code != RiskAccountBlocked {
+		t.Fatalf("expected blocked, got %q", code)
+	}
+	book, _ := ex.Book("RSK")
+	snapshot, _ := book.L3Snapshot()
+	if len(snapshot.Bids) != 0 || len(snapshot.Asks) != 0 {
+		t.Fatalf("expected the kill switch to cancel every order, got %+v", snapshot)
+	}
+
+	// Cancels settle from execution reports on their own goroutine.
+	for deadline := time.Now().Add(2 * time.Second); gw.OpenOrders("big") != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no working orders, got %d", gw.OpenOrders("big"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	gw.Unblock("big")
+	if err := gw.SubmitOrder(order("b4", "big", Buy, 90, 1)); err != nil {
+		t.Fatalf("submit after unblock: %v", err)
+	}
+
+	// Amends and replaces of orders the gateway is not tracking are checked
+	// all the same.
+	if err := ex.SubmitOrder(order("direct2", "big", Buy, 85, 1)); err != nil {
+		t.Fatalf("submit direct2: %v", err)
+	}
+	far, big := int64(50), int64(101)
+	if code := riskCode(gw.AmendOrder("RSK", "direct2", &far, nil)); code != RiskPriceCollar {
+		t.Fatalf("expected collar on untracked amend, got %q", code)
+	}
+	if code := riskCode(gw.ReplaceOrder("RSK", "direct2", "direct3", nil, &big)); code != RiskMaxQuantity {
+		t.Fatalf("expected max quantity on untracked replace, got %q", code)
+	}
+}
+
+func TestRiskGatewayBlockCatchesInFlightSubmits(t *testing.T) {
+	ex, err := NewExchange([]OrderBookConfig{{Symbol: "RSK", TickSize: 1, MaxDepth: 100000}})
+	if err != nil {
+		t.Fatalf("new exchange: %v", err)
+	}
+	defer ex.Stop()
+	gw := NewRiskGateway(ex, ex, RiskConfig{})
+
+	for round := 0; round < 50; round++ {
+		account := fmt.Sprintf("acct%d", round)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					order := Order{ID: fmt.Sprintf("%s-%d-%d", account, w, i), Symbol: "RSK", Side: Buy, Type: Limit, Price: 10, Quantity: 1, Owner: account}
+					var err error
+					if i%2 == 0 {
+						err = gw.SubmitOrder(order)
+					} else {
+						err = gw.SubmitOrders([]Order{order})[0]
+					}
+					if riskCode(err) == RiskAccountBlocked {
+						return
+					}
+				}
+			}(w)
+		}
+		time.Sleep(time.Millisecond)
+		gw.Block(account)
+		wg.Wait()
+		if open, _ := ex.Orders(account, ScopeOpen); len(open) != 0 {
+			t.Fatalf("round %d: %d orders landed after the kill switch", round, len(open))
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotMagic starts every snapshot file; the last byte is the format version.
+var snapshotMagic = []byte("LMTSNAP\x01")
+
+// snapshotsKept is how many snapshots stay on disk. Journal segments are only
+// dropped once the older of them covers them, so a corrupt latest snapshot
+// can still be recovered from its predecessor.
+const snapshotsKept = 2
+
+// bookSnapshot is a point-in-time copy of a book's state; index is the last
+// journal record it includes.
+type bookSnapshot struct {
+	symbol    string
+	tickSize  int64
+	maxDepth  int
+	index     int64
+	seq       int64
+	tradeSeq  int64
+	l3Seq     int64
+	lastPrice int64
+	resting   []restingOrder
+	stops     []Order
+	history   []closedOrder // oldest first
+}
+
+type restingOrder struct {
+	order   Order
+	visible int64
+}
+
+// maybeSnapshot captures the book once enough records have been journaled
+// since the last snapshot. The copy is taken on the worker; encoding and
+// writing happen in the background, one snapshot at a time. A failed write
+// keeps every journal segment, so it only delays truncation.
+func (ob *OrderBook) maybeSnapshot() {
+	every := ob.cfg.Journal.SnapshotEvery
+	if ob.journal == nil || every <= 0 || ob.sinceSnapshot < every {
+		return
+	}
+	if !ob.snapshotBusy.CompareAndSwap(false, true) {
+		return
+	}
+	index, err := ob.journal.Rotate()
+	if err != nil {
+		ob.snapshotBusy.Store(false)
+		return
+	}
+	ob.sinceSnapshot = 0
+	snap := ob.captureSnapshot(index)
+
+	ob.snapshotWG.Add(1)
+	go func() {
+		defer ob.snapshotWG.Done()
+		defer ob.snapshotBusy.Store(false)
+		if err := writeSnapshot(ob.cfg.Journal.Path, snap); err != nil {
+			return
+		}
+		_ = ob.pruneSnapshots()
+	}()
+}
+
+func (ob *OrderBook) captureSnapshot(index int64) *bookSnapshot {
+	snap := &bookSnapshot{
+		symbol:    ob.cfg.Symbol,
+		tickSize:  ob.cfg.TickSize,
+		maxDepth:  ob.cfg.MaxDepth,
+		index:     index,
+		seq:       ob.seq,
+		tradeSeq:  ob.tradeSeq,
+		l3Seq:     ob.l3Seq,
+		lastPrice: ob.lastPrice,
+		resting:   make([]restingOrder, 0, len(ob.orders)),
+		stops:     make([]Order, 0, len(ob.triggers.byID)),
+	}
+	for _, side := range []*bookSide{&ob.bids, &ob.asks} {
+		side.each(func(entry *orderEntry) bool {
+			snap.resting = append(snap.resting, restingOrder{order: *entry.order, visible: entry.visible})
+			return true
+		})
+	}
+	for _, side := range [][]*Order{ob.triggers.buys, ob.triggers.sells} {
+		for _, stop := range side {
+			snap.stops = append(snap.stops, *stop)
+		}
+	}
+	ob.history.each(func(closed closedOrder) {
+		snap.history = append(snap.history, closed)
+	})
+	return snap
+}
+
+// pruneSnapshots keeps the newest snapshots and drops the journal segments
+// the oldest kept one already covers.
+func (ob *OrderBook) pruneSnapshots() error {
+	snaps, err := numberedFiles(ob.cfg.Journal.Path + ".snap.")
+	if err != nil || len(snaps) < snapshotsKept {
+		return err
+	}
+	for _, old := range snaps[:len(snaps)-snapshotsKept] {
+		if err := os.Remove(old.path); err != nil {
+			return fmt.Errorf("remove snapshot: %w", err)
+		}
+	}
+	return ob.journal.Prune(snaps[len(snaps)-snapshotsKept].n)
+}
+
+// restore loads a snapshot into an empty book.
+func (ob *OrderBook) restore(snap *bookSnapshot) error {
+	if snap.symbol != ob.cfg.Symbol {
+		return fmt.Errorf("snapshot is for %s, not %s", snap.symbol, ob.cfg.Symbol)
+	}
+	if snap.tickSize != ob.cfg.TickSize {
+		return fmt.Errorf("snapshot tick size %d does not match configured %d", snap.tickSize, ob.cfg.TickSize)
+	}
+	ob.seq = snap.seq
+	ob.tradeSeq = snap.tradeSeq
+	ob.l3Seq = snap.l3Seq
+	ob.lastPrice = snap.lastPrice
+	// Pushing in Sequence order rebuilds every level's queue as it was.
+	sort.Slice(snap.resting, func(i, j int) bool {
+		return snap.resting[i].order.Sequence < snap.resting[j].order.Sequence
+	})
+	for _, rest := range snap.resting {
+		order := rest.order
+		entry := ob.newEntry(&order)
+		entry.visible = rest.visible
+		ob.side(entry.isBid).push(entry)
+		ob.orders[order.ID] = entry
+		if !order.ExpireAt.IsZero() {
+			heap.Push(&ob.expiries, expiryItem{at: order.ExpireAt, id: order.ID})
+		}
+	}
+	for _, stop := range snap.stops {
+		order := stop
+		ob.triggers.add(&order)
+		if !order.ExpireAt.IsZero() {
+			heap.Push(&ob.expiries, expiryItem{at: order.ExpireAt, id: order.ID})
+		}
+	}
+	for i := range snap.history {
+		closed := &snap.history[i]
+		ob.history.record(&closed.order, closed.state, closed.finished)
+	}
+	return nil
+}
+
+// writeSnapshot encodes snap and atomically installs it beside the journal.
+func writeSnapshot(journalPath string, snap *bookSnapshot) error {
+	name := numberedName(journalPath+".snap.", snap.index)
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	if _, err := file.Write(encodeSnapshot(snap)); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+	if dir, err := os.Open(filepath.Dir(name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// loadSnapshot returns the newest snapshot beside the journal that decodes
+// cleanly, or nil if there is none. Corrupt snapshots are skipped.
+func loadSnapshot(journalPath string) (*bookSnapshot, error) {
+	snaps, err := numberedFiles(journalPath + ".snap.")
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snaps) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(snaps[i].path)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot: %w", err)
+		}
+		if snap, err := decodeSnapshot(data); err == nil {
+			return snap, nil
+		}
+	}
+	return nil, nil
+}
+
+// encodeSnapshot lays the snapshot out as the magic, varint-encoded fields,
+// and a trailing big-endian CRC32 of everything before it.
+func encodeSnapshot(snap *bookSnapshot) []byte {
+	var e snapshotEncoder
+	e.buf = append(e.buf, snapshotMagic...)
+	e.string(snap.symbol)
+	e.varint(snap.tickSize)
+	e.varint(int64(snap.maxDepth))
+	e.varint(snap.index)
+	e.varint(snap.seq)
+	e.varint(snap.lastPrice)
+	e.varint(snap.tradeSeq)
+	e.varint(snap.l3Seq)
+	e.varint(int64(len(snap.resting)))
+	for i := range snap.resting {
+		e.order(&snap.resting[i].order)
+		e.varint(snap.resting[i].visible)
+	}
+	e.varint(int64(len(snap.stops)))
+	for i := range snap.stops {
+		e.order(&snap.stops[i])
+	}
+	e.varint(int64(len(snap.history)))
+	for i := range snap.history {
+		e.order(&snap.history[i].order)
+		e.varint(int64(snap.history[i].state))
+		e.time(snap.history[i].finished)
+	}
+	return binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+}
+
+func decodeSnapshot(data []byte) (*bookSnapshot, error) {
+	prefix := len(snapshotMagic) - 1
+	if len(data) < len(snapshotMagic)+4 || !bytes.Equal(data[:prefix], snapshotMagic[:prefix]) {
+		return nil, errors.New("not a snapshot file")
+	}
+	if version := data[prefix]; version != snapshotMagic[prefix] {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	d := snapshotDecoder{buf: body[len(snapshotMagic):]}
+	snap := &bookSnapshot{
+		symbol:    d.string(),
+		tickSize:  d.varint(),
+		maxDepth:  int(d.varint()),
+		index:     d.varint(),
+		seq:       d.varint(),
+		lastPrice: d.varint(),
+		tradeSeq:  d.varint(),
+		l3Seq:     d.varint(),
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		order := d.order()
+		snap.resting = append(snap.resting, restingOrder{order: order, visible: d.varint()})
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		snap.stops = append(snap.stops, d.order())
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		order := d.order()
+		state := OrderState(d.varint())
+		snap.history = append(snap.history, closedOrder{order: order, state: state, finished: d.time()})
+	}
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errors.New("trailing bytes")
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", d.err)
+	}
+	return snap, nil
+}
+
+type snapshotEncoder struct {
+	buf []byte
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.varint(int64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *snapshotEncoder) time(t time.Time) {
+	data, _ := t.MarshalBinary()
+	e.varint(int64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+func (e *snapshotEncoder) order(o *Order) {
+	e.string(o.ID)
+	e.string(o.Symbol)
+	e.varint(int64(o.Side))
+	e.varint(int64(o.Type))
+	e.varint(o.Price)
+	e.varint(o.StopPrice)
+	e.varint(o.Quantity)
+	e.varint(o.Remaining)
+	e.varint(o.DisplayQuantity)
+	e.time(o.Timestamp)
+	e.varint(o.Sequence)
+	e.varint(int64(o.TimeInForce))
+	e.time(o.ExpireAt)
+	e.varint(int64(o.PostOnly))
+	e.varint(o.filled)
+	e.string(o.Owner)
+	e.varint(int64(o.SelfTrade))
+	e.varint(o.notional)
+	e.string(o.ClientOrderID)
+}
+
+// snapshotDecoder reads fields back in encoding order. The first failure is
+// kept in err and every later read returns a zero value.
+type snapshotDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	n := d.varint()
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > int64(len(d.buf)) {
+		d.err = errors.New("field overruns snapshot")
+		return nil
+	}
+	out := d.buf[:n]
+	d.buf = d.buf[n:]
+	return out
+}
+
+func (d *snapshotDecoder) count() int64 {
+	n := d.varint()
+	if n < 0 || n > int64(len(d.buf)) {
+		d.err = errors.New("bad count")
+		return 0
+	}
+	return n
+}
+
+func (d *snapshotDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *snapshotDecoder) time() time.Time {
+	var t time.Time
+	if data := d.bytes(); d.err == nil {
+		if err := t.UnmarshalBinary(data); err != nil {
+			d.err = err
+		}
+	}
+	return t
+}
+
+func (d *snapshotDecoder) order() Order {
+	return Order{
+		ID:              d.string(),
+		Symbol:          d.string(),
+		Side:            Side(d.varint()),
+		Type:            OrderType(d.varint()),
+		Price:           d.varint(),
+		StopPrice:       d.varint(),
+		Quantity:        d.varint(),
+		Remaining:       d.varint(),
+		DisplayQuantity: d.varint(),
+		Timestamp:       d.time(),
+		Sequence:        d.varint(),
+		TimeInForce:     TimeInForce(d.varint()),
+		ExpireAt:        d.time(),
+		PostOnly:        PostOnlyMode(d.varint()),
+		filled:          d.varint(),
+		Owner:           d.string(),
+		SelfTrade:       SelfTradeMode(d.varint()),
+		notional:        d.varint(),
+		ClientOrderID:   d.string(),
+	}
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotTruncatesJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "BTCUSD.journal")
+	clock := NewSimClock(time.Unix(0, 0))
+	cfg := OrderBookConfig{
+		Symbol:   "BTCUSD",
+		TickSize: 1,
+		MaxDepth: 10,
+		Inline:   true,
+		Journal:  JournalConfig{Path: path, SnapshotEvery: 3},
+		Clock:    clock,
+	}
+
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+	// Only the snapshots will remember this order once the journal is cut.
+	if err := ob.SubmitOrder(Order{ID: "gone", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 50, Quantity: 1}); err != nil {
+		t.Fatalf("submit gone: %v", err)
+	}
+	if err := ob.CancelOrder("gone"); err != nil {
+		t.Fatalf("cancel gone: %v", err)
+	}
+	for i := int64(0); i < 10; i++ {
+		order := Order{ID: "bid" + string(rune('a'+i)), Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 90 + i, Quantity: 2, DisplayQuantity: 1}
+		if err := ob.SubmitOrder(order); err != nil {
+			t.Fatalf("submit %s: %v", order.ID, err)
+		}
+		ob.snapshotWG.Wait()
+	}
+	if err := ob.SubmitOrder(Order{ID: "ask", Symbol: "BTCUSD", Side: Sell, Type: Market, Quantity: 1}); err != nil {
+		t.Fatalf("submit market: %v", err)
+	}
+	if err := ob.CancelOrder("bida"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if err := ob.SubmitOrder(Order{ID: "stop", Symbol: "BTCUSD", Side: Sell, Type: Stop, StopPrice: 80, Quantity: 1}); err != nil {
+		t.Fatalf("submit stop: %v", err)
+	}
+	before, _ := ob.L3Snapshot()
+	seq := ob.seq
+	ob.Stop()
+
+	snaps, _ := numberedFiles(path + ".snap.")
+	if len(snaps) != snapshotsKept {
+		t.Fatalf("expected %d snapshots on disk, got %d", snapshotsKept, len(snaps))
+	}
+	_, records, err := OpenJournal(JournalConfig{Path: path, Sync: SyncNever})
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	if len(records) == 0 || records[0].Index == 1 || records[0].Index > snaps[0].n+1 {
+		t.Fatalf("expected journal truncated to just after snapshot %d, got %d records", snaps[0].n, len(records))
+	}
+
+	recovered, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("recover book: %v", err)
+	}
+	defer recovered.Stop()
+	clock.Set(time.Unix(1, 0))
+
+	if recovered.seq != seq {
+		t.Fatalf("expected sequence %d, got %d", seq, recovered.seq)
+	}
+	after, _ := recovered.L3Snapshot()
+	if len(after.Bids) != len(before.Bids) {
+		t.Fatalf("expected %d bids, got %d", len(before.Bids), len(after.Bids))
+	}
+	for i := range before.Bids {
+		b, a := before.Bids[i], after.Bids[i]
+		if a.ID != b.ID || a.Remaining != b.Remaining || a.Sequence != b.Sequence {
+			t.Fatalf("bid %d: expected %+v, got %+v", i, b, a)
+		}
+	}
+	if stops, _ := recovered.StopOrders(); len(stops) != 1 || stops[0].ID != "stop" {
+		t.Fatalf("expected stop to survive recovery, got %+v", stops)
+	}
+	if status, err := recovered.OrderStatus("gone"); err != nil || status.State != StateCanceled {
+		t.Fatalf("expected the canceled order to survive recovery, got %+v, %v", status, err)
+	}
+	if code := orderCode(recovered.SubmitOrder(Order{ID: "gone", Symbol: "BTCUSD", Side: Buy, Type: Limit, Price: 50, Quantity: 1})); code != OrderDuplicateID {
+		t.Fatalf("expected the finished ID to stay taken after recovery, got %q", code)
+	}
+}
+
+func TestCorruptSnapshotFallsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ETHUSD.journal")
+	cfg := OrderBookConfig{Symbol: "ETHUSD", TickSize: 1, MaxDepth: 10, Inline: true, Journal: JournalConfig{Path: path, SnapshotEvery: 2}}
+
+	ob, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("open book: %v", err)
+	}
+	for i := int64(1); i <= 6; i++ {
+		if err := ob.SubmitOrder(Order{ID: "ask" + string(rune('0'+i)), Symbol: "ETHUSD", Side: Sell, Type: Limit, Price: 100 + i, Quantity: 1}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		ob.snapshotWG.Wait()
+	}
+	ob.Stop()
+
+	snaps, _ := numberedFiles(path + ".snap.")
+	newest := snaps[len(snaps)-1].path
+	data, _ := os.ReadFile(newest)
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(newest, data, 0o644); err != nil {
+		t.Fatalf("corrupt snapshot: %v", err)
+	}
+	if _, err := decodeSnapshot(data); err == nil {
+		t.Fatalf("checksum should catch corruption")
+	}
+
+	recovered, err := OpenOrderBook(cfg)
+	if err != nil {
+		t.Fatalf("recover from older snapshot: %v", err)
+	}
+	defer recovered.Stop()
+	if len(recovered.orders) != 6 || recovered.seq != 6 {
+		t.Fatalf("expected 6 orders at sequence 6, got %d at %d", len(recovered.orders), recovered.seq)
+	}
+}
@@ -0,0 +1,105 @@
+package engine
+
+import "sort"
+
+// triggerBook holds stop orders until a trade price reaches their stop.
+// Buy stops are kept in ascending stop price order and sell stops in
+// descending order, so the triggered orders always form a prefix.
+type triggerBook struct {
+	buys  []*Order
+	sells []*Order
+	byID  map[string]*Order
+}
+
+func newTriggerBook() triggerBook {
+	return triggerBook{byID: make(map[string]*Order)}
+}
+
+func (t *triggerBook) add(order *Order) {
+	if order.Side == Buy {
+		t.buys = insertStop(t.buys, order, func(a, b *Order) bool { return a.StopPrice < b.StopPrice })
+	} else {
+		t.sells = insertStop(t.sells, order, func(a, b *Order) bool { return a.StopPrice > b.StopPrice })
+	}
+	t.byID[order.ID] = order
+}
+
+func insertStop(list []*Order, order *Order, before func(a, b *Order) bool) []*Order {
+	idx := sort.Search(len(list), func(i int) bool { return before(order, list[i]) })
+	list = append(list, nil)
+	copy(list[idx+1:], list[idx:])
+	list[idx] = order
+	return list
+}
+
+func (t *triggerBook) get(id string) (*Order, bool) {
+	order, ok := t.byID[id]
+	return order, ok
+}
+
+func (t *triggerBook) remove(id string) (*Order, bool) {
+	order, ok := t.byID[id]
+	if !ok {
+		return nil, false
+	}
+	delete(t.byID, id)
+	if order.Side == Buy {
+		t.buys = removeStop(t.buys, order)
+	} else {
+		t.sells = removeStop(t.sells, order)
+	}
+	return order, true
+}
+
+func removeStop(list []*Order, order *Order) []*Order {
+	for i := range list {
+		if list[i] == order {
+			copy(list[i:], list[i+1:])
+			list[len(list)-1] = nil
+			return list[:len(list)-1]
+		}
+	}
+	return list
+}
+
+// next pops the triggered stop with the lowest sequence number, so stops that
+// fire together are released in the order they were accepted.
+func (t *triggerBook) next(lastPrice int64) (*Order, bool) {
+	if lastPrice <= 0 {
+		return nil, false
+	}
+	var found *Order
+	for _, order := range t.buys {
+		if order.StopPrice > lastPrice {
+			break
+		}
+		if found == nil || order.Sequence < found.Sequence {
+			found = order
+		}
+	}
+	for _, order := range t.sells {
+		if order.StopPrice < lastPrice {
+			break
+		}
+		if found == nil || order.Sequence < found.Sequence {
+			found = order
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	t.remove(found.ID)
+	return found, true
+}
+
+// list returns copies of every pending stop, buys first, each in trigger order.
+func (t *triggerBook) list() []Order {
+	out := make([]Order, 0, len(t.byID))
+	for _, order := range t.buys {
+		out = append(out, *order)
+	}
+	for _, order := range t.sells {
+		out = append(out, *order)
+	}
+	return out
+}
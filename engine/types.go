@@ -1,6 +1,9 @@
 package engine
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Side represents the direction of an order.
 type Side int
@@ -20,6 +23,59 @@ const (
 	Limit OrderType = iota
 	// Market orders consume available liquidity immediately.
 	Market
+	// Stop orders wait in the trigger book and become market orders once a
+	// trade prints at or through StopPrice.
+	Stop
+	// StopLimit orders wait in the trigger book and become limit orders at
+	// Price once a trade prints at or through StopPrice.
+	StopLimit
+)
+
+// TimeInForce controls how long an order remains eligible to trade.
+type TimeInForce int
+
+const (
+	// GTC orders rest until filled or canceled.
+	GTC TimeInForce = iota
+	// IOC orders fill what they can on arrival and cancel the remainder.
+	IOC
+	// FOK orders must fill completely on arrival or are rejected untouched.
+	FOK
+	// DAY orders expire at the end of the trading day (UTC midnight).
+	DAY
+	// GTD orders expire at their ExpireAt time.
+	GTD
+)
+
+// PostOnlyMode controls how a post-only order behaves when it would take liquidity.
+type PostOnlyMode int
+
+const (
+	// PostOnlyOff lets the order match normally.
+	PostOnlyOff PostOnlyMode = iota
+	// PostOnlyReject rejects the order if it would cross the opposite best.
+	PostOnlyReject
+	// PostOnlySlide reprices the order one tick behind the opposite best instead of crossing.
+	PostOnlySlide
+)
+
+// SelfTradeMode decides what happens when an order would trade against a
+// resting order with the same Owner. The incoming order's mode applies.
+type SelfTradeMode int
+
+const (
+	// SelfTradeAllow lets orders of the same owner trade with each other.
+	SelfTradeAllow SelfTradeMode = iota
+	// SelfTradeCancelNewest cancels the rest of the incoming order.
+	SelfTradeCancelNewest
+	// SelfTradeCancelOldest cancels the resting order and keeps matching.
+	SelfTradeCancelOldest
+	// SelfTradeCancelBoth cancels the resting order and the rest of the
+	// incoming order.
+	SelfTradeCancelBoth
+	// SelfTradeDecrement reduces both orders by the smaller remaining
+	// quantity, canceling whichever reaches zero.
+	SelfTradeDecrement
 )
 
 // Order describes a request to trade a symbol.
@@ -29,26 +85,234 @@ type Order struct {
 	Side      Side
 	Type      OrderType
 	Price     int64 // expressed in ticks
+	StopPrice int64 // trigger price for Stop and StopLimit orders
 	Quantity  int64
 	Remaining int64
-	Timestamp time.Time
-	Sequence  int64
+	// DisplayQuantity, when positive, makes a resting limit order an iceberg:
+	// only this much is shown at a time and the rest is held in reserve.
+	DisplayQuantity int64
+	Timestamp       time.Time
+	Sequence        int64
+
+	TimeInForce TimeInForce
+	ExpireAt    time.Time // required for GTD, assigned by the book for DAY
+	PostOnly    PostOnlyMode
+
+	// Owner identifies the account behind the order. Orders with the same
+	// non-empty Owner are subject to SelfTrade.
+	Owner     string
+	SelfTrade SelfTradeMode
+
+	// ClientOrderID is the submitter's own reference for the order. The book
+	// keys orders by ID and only carries it through to reports.
+	ClientOrderID string
+
+	filled   int64 // cumulative executed quantity, maintained by the book
+	notional int64 // sum of price times quantity over every fill
 }
 
 // BookView summarizes top-of-book information for a symbol.
 type BookView struct {
+	Symbol  string
 	BestBid *Order
 	BestAsk *Order
 }
 
-// MatchResult captures a completed trade.
+// PriceLevel aggregates the visible resting quantity at one price.
+type PriceLevel struct {
+	Price    int64
+	Quantity int64
+	Orders   int
+}
+
+// Depth is an aggregated ladder snapshot, best prices first. Sequence is the
+// last DepthUpdate already reflected in the snapshot.
+type Depth struct {
+	Symbol   string
+	Sequence int64
+	Bids     []PriceLevel
+	Asks     []PriceLevel
+}
+
+// DepthUpdate lists the price levels changed by one book event. A level with
+// zero Quantity has been removed. Sequence increases by one per update.
+type DepthUpdate struct {
+	Symbol   string
+	Sequence int64
+	Bids     []PriceLevel
+	Asks     []PriceLevel
+}
+
+// L3EventType identifies a market-by-order event.
+type L3EventType int
+
+const (
+	// L3Add places a new order at the back of the queue at its price.
+	L3Add L3EventType = iota
+	// L3Modify changes an order's price and/or quantity; the engine always
+	// re-queues amended orders, so the order moves to the back at its price.
+	L3Modify
+	// L3Delete removes an order from the book.
+	L3Delete
+	// L3Execute reduces a resting order by a traded quantity. An order whose
+	// visible quantity reaches zero leaves the book; iceberg refreshes then
+	// arrive as a new L3Add.
+	L3Execute
+)
+
+// L3Event is one order-by-order market data event. Quantity is the visible
+// resting quantity for Add and Modify and the traded quantity for Execute.
+type L3Event struct {
+	Sequence  int64
+	Type      L3EventType
+	OrderID   string
+	Symbol    string
+	Side      Side
+	Price     int64
+	Quantity  int64
+	Timestamp time.Time
+}
+
+// L3Snapshot lists every visible resting order in priority order. Sequence is
+// the last L3Event already reflected in the snapshot.
+type L3Snapshot struct {
+	Symbol   string
+	Sequence int64
+	Bids     []Order
+	Asks     []Order
+}
+
+// MatchResult captures a completed trade. The maker is the order that was
+// resting on the book; the taker is the incoming order that crossed it, and
+// AggressorSide is the taker's side.
 type MatchResult struct {
-	Symbol      string
-	BuyOrderID  string
-	SellOrderID string
-	Price       int64
-	Quantity    int64
-	Timestamp   time.Time
+	TradeID       string // unique per book, and across books since it carries the symbol
+	Symbol        string
+	BuyOrderID    string
+	SellOrderID   string
+	MakerOrderID  string
+	TakerOrderID  string
+	AggressorSide Side
+	Price         int64
+	Quantity      int64
+	BuyFee        int64 // fee charged to the buyer; negative for a rebate
+	SellFee       int64 // fee charged to the seller; negative for a rebate
+	Sequence      int64 // L3 sequence of the execute event this trade produced
+	Timestamp     time.Time
+}
+
+// OrderState describes where an order is in its lifecycle.
+type OrderState int
+
+const (
+	// StateNew means the order was accepted by the book.
+	StateNew OrderState = iota
+	// StatePartiallyFilled means the order traded and still has quantity open.
+	StatePartiallyFilled
+	// StateFilled means the order traded its full quantity.
+	StateFilled
+	// StateCanceled means the order was removed before filling completely.
+	StateCanceled
+	// StateRejected means the order was refused without touching the book.
+	StateRejected
+	// StateReplaced means the order's price or quantity was amended, its
+	// quantity decremented by self-trade prevention, or, as a stop, it was
+	// triggered and entered the book.
+	StateReplaced
+	// StateExpired means the order reached the end of its time in force.
+	StateExpired
+)
+
+// OrderStatus is where an order stands: still working, or finished and
+// remembered by the book.
+type OrderStatus struct {
+	Order     Order      // the order as last seen; Remaining is what still works
+	State     OrderState // New or PartiallyFilled while working
+	CumQty    int64
+	AvgPrice  float64 // volume-weighted fill price, zero before any fill
+	LeavesQty int64
+}
+
+// OrderScope selects the orders Orders lists.
+type OrderScope int
+
+const (
+	// ScopeOpen lists resting orders and pending stops.
+	ScopeOpen OrderScope = iota
+	// ScopeClosed lists finished orders the book still remembers.
+	ScopeClosed
+	// ScopeAll lists both.
+	ScopeAll
+)
+
+// Reasons attached to execution reports for cancellations the client did not request.
+const (
+	ReasonCanceled        = "canceled by request"
+	ReasonIOCRemainder    = "unfilled IOC remainder"
+	ReasonMarketRemainder = "unfilled market remainder"
+	ReasonDepthTrimmed    = "trimmed by max depth"
+	ReasonStopNotExecuted = "triggered stop could not execute"
+	ReasonSelfTrade       = "self-trade prevented"
+	ReasonReplaced        = "replaced by a new order"
+	ReasonMassCanceled    = "canceled by mass cancel"
+	ReasonStopTriggered   = "stop triggered"
+)
+
+// Codes carried by OrderError.
+const (
+	OrderNotFound    = "not_found"
+	OrderFilled      = "already_filled"
+	OrderClosed      = "already_closed" // canceled, expired or rejected
+	OrderPendingStop = "pending_stop"
+	OrderDuplicateID = "duplicate_id"
+	OrderBadTick     = "bad_tick"
+	OrderBadQuantity = "bad_quantity"
+	OrderWouldCross  = "would_cross" // an amend may not take liquidity
+)
+
+// OrderError is a book's refusal of a request for a reason callers may want
+// to tell apart. Code is one of the Order* codes above.
+type OrderError struct {
+	Code   string
+	Reason string
+}
+
+func (e *OrderError) Error() string {
+	return e.Reason
+}
+
+func orderError(code, format string, args ...any) error {
+	return &OrderError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ExecutionReport describes a single lifecycle event for an order.
+type ExecutionReport struct {
+	OrderID   string
+	Owner     string
+	Symbol    string
+	Side      Side
+	Type      OrderType
+	State     OrderState
+	Price     int64
+	Quantity  int64
+	LastPrice int64 // price of this fill, for fill states
+	LastQty   int64 // quantity of this fill, for fill states
+	Fee       int64 // fee on this fill, negative for a rebate
+	Maker     bool  // this fill was against the order resting on the book
+	CumQty    int64
+	LeavesQty int64
+	Reason    string
+	Timestamp time.Time
+
+	ClientOrderID string // the order's, if it has one
+}
+
+// CancelFilter picks the working orders a mass cancel removes. Empty fields
+// match every order; a book ignores Symbol and cancels only its own.
+type CancelFilter struct {
+	Owner  string
+	Symbol string
+	Side   *Side
 }
 
 // OrderBookConfig controls book parameters.
@@ -58,4 +322,16 @@ type OrderBookConfig struct {
 	MaxDepth      int
 	RequestBuffer int
 	Inline        bool
+	Journal       JournalConfig // write-ahead journal; empty Path disables it
+	TradePolicy   OutputPolicy  // what to do with trades a consumer is too slow for; see Trades
+	Fees          *FeeSchedule  // maker/taker fees on fills; nil charges none
+	History       int           // finished orders remembered for lookups; 0 means 10000
+	// DedupWindow is how long the ID of a finished order stays taken; 0 means
+	// an hour and a negative window frees it at once. IDs of working orders
+	// are always taken, and only the last History finished orders are known.
+	DedupWindow time.Duration
+	// Clock defaults to the system clock. With any other clock the worker
+	// does not arm its expiry timer; orders expire on the next request once
+	// the clock passes their deadline.
+	Clock Clock
 }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"limitless/engine"
+)
+
+type instrumentConfig struct {
+	Symbol   string `json:"symbol"`
+	TickSize int64  `json:"tickSize"`
+	MaxDepth int    `json:"maxDepth"`
+}
+
+// loadInstruments reads the instrument list from INSTRUMENTS_FILE when set,
+// otherwise it serves a single instrument from SYMBOL. TICK_SIZE and
+// MAX_DEPTH fill in any value an instrument leaves out.
+func loadInstruments() ([]engine.OrderBookConfig, error) {
+	cfgs, err := readInstruments()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOutput(cfgs); err != nil {
+		return nil, err
+	}
+	if err := applyFees(cfgs); err != nil {
+		return nil, err
+	}
+	if err := applyDedup(cfgs); err != nil {
+		return nil, err
+	}
+	return cfgs, applyJournal(cfgs)
+}
+
+// applyDedup sets how long order IDs, and the answers to orders with client
+// order IDs, are remembered from DEDUP_WINDOW (default 1h).
+func applyDedup(cfgs []engine.OrderBookConfig) error {
+	window, err := time.ParseDuration(getEnv("DEDUP_WINDOW", "1h"))
+	if err != nil {
+		return fmt.Errorf("parse DEDUP_WINDOW: %w", err)
+	}
+	if window <= 0 {
+		return fmt.Errorf("DEDUP_WINDOW must be positive, got %s", window)
+	}
+	for i := range cfgs {
+		cfgs[i].DedupWindow = window
+	}
+	return nil
+}
+
+// applyFees charges maker and taker fees when FEES_FILE names a JSON fee
+// schedule (see engine.ReadFeeSchedule).
+func applyFees(cfgs []engine.OrderBookConfig) error {
+	path := os.Getenv("FEES_FILE")
+	if path == "" {
+		return nil
+	}
+	schedule, err := engine.ReadFeeSchedule(path)
+	if err != nil {
+		return err
+	}
+	for i := range cfgs {
+		cfgs[i].Fees = schedule
+	}
+	return nil
+}
+
+type accountConfig struct {
+	ID        string           `json:"id"`
+	Cash      int64            `json:"cash"`
+	Positions map[string]int64 `json:"positions"`
+}
+
+// loadAccounts enables funds checks when ACCOUNTS_FILE names a JSON list of
+// {id, cash, positions} starting balances. Without it orders are not checked
+// and nil is returned.
+func loadAccounts(exchange *engine.Exchange) (*engine.Accounts, error) {
+	path := os.Getenv("ACCOUNTS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read accounts: %w", err)
+	}
+	var cfgs []accountConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("parse accounts %s: %w", path, err)
+	}
+
+	accounts := engine.NewAccounts(exchange)
+	for _, cfg := range cfgs {
+		if err := accounts.Deposit(cfg.ID, cfg.Cash); err != nil {
+			return nil, fmt.Errorf("account %q: %w", cfg.ID, err)
+		}
+		for symbol, qty := range cfg.Positions {
+			if err := accounts.DepositPosition(cfg.ID, symbol, qty); err != nil {
+				return nil, fmt.Errorf("account %q: %w", cfg.ID, err)
+			}
+		}
+	}
+	return accounts, nil
+}
+
+type riskLimitsConfig struct {
+	MaxOrderQuantity int64   `json:"maxOrderQuantity"`
+	MaxNotional      int64   `json:"maxNotional"`
+	CollarTicks      int64   `json:"collarTicks"`
+	CollarPercent    float64 `json:"collarPercent"`
+	CollarFrom       string  `json:"collarFrom"`
+	MaxOpenOrders    int     `json:"maxOpenOrders"`
+	MaxPosition      int64   `json:"maxPosition"`
+	MaxMessageRate   int     `json:"maxMessageRate"`
+}
+
+type riskConfig struct {
+	Default  riskLimitsConfig            `json:"default"`
+	Symbols  map[string]riskLimitsConfig `json:"symbols"`
+	Accounts map[string]riskLimitsConfig `json:"accounts"`
+}
+
+// loadRisk puts a RiskGateway in front of next when RISK_FILE names a JSON
+// file of default, per-symbol and per-account limits. Without it orders are
+// not risk checked and nil is returned.
+func loadRisk(exchange *engine.Exchange, next engine.OrderRouter) (*engine.RiskGateway, error) {
+	path := os.Getenv("RISK_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read risk limits: %w", err)
+	}
+	var cfg riskConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse risk limits %s: %w", path, err)
+	}
+
+	var risk engine.RiskConfig
+	if risk.Default, err = cfg.Default.limits(); err != nil {
+		return nil, fmt.Errorf("default risk limits: %w", err)
+	}
+	if risk.Symbols, err = limitsByKey(cfg.Symbols); err != nil {
+		return nil, err
+	}
+	if risk.Accounts, err = limitsByKey(cfg.Accounts); err != nil {
+		return nil, err
+	}
+	return engine.NewRiskGateway(exchange, next, risk), nil
+}
+
+func limitsByKey(cfgs map[string]riskLimitsConfig) (map[string]engine.RiskLimits, error) {
+	out := make(map[string]engine.RiskLimits, len(cfgs))
+	for key, cfg := range cfgs {
+		limits, err := cfg.limits()
+		if err != nil {
+			return nil, fmt.Errorf("risk limits for %s: %w", key, err)
+		}
+		out[key] = limits
+	}
+	return out, nil
+}
+
+func (c riskLimitsConfig) limits() (engine.RiskLimits, error) {
+	limits := engine.RiskLimits{
+		MaxOrderQuantity: c.MaxOrderQuantity,
+		MaxNotional:      c.MaxNotional,
+		CollarTicks:      c.CollarTicks,
+		CollarPercent:    c.CollarPercent,
+		MaxOpenOrders:    c.MaxOpenOrders,
+		MaxPosition:      c.MaxPosition,
+		MaxMessageRate:   c.MaxMessageRate,
+	}
+	switch c.CollarFrom {
+	case "", "last":
+		limits.CollarFrom = engine.CollarLastTrade
+	case "mid":
+		limits.CollarFrom = engine.CollarMid
+	default:
+		return engine.RiskLimits{}, fmt.Errorf("unknown collarFrom %q", c.CollarFrom)
+	}
+	return limits, nil
+}
+
+// applyOutput sets what each book does with trades the server falls behind
+// on: TRADE_OUTPUT is block, drop or spill (the default).
+func applyOutput(cfgs []engine.OrderBookConfig) error {
+	var policy engine.OutputPolicy
+	switch output := getEnv("TRADE_OUTPUT", "spill"); output {
+	case "block":
+		policy = engine.OutputBlock
+	case "drop":
+		policy = engine.OutputDrop
+	case "spill":
+		policy = engine.OutputSpill
+	default:
+		return fmt.Errorf("unknown TRADE_OUTPUT %q", output)
+	}
+	for i := range cfgs {
+		cfgs[i].TradePolicy = policy
+	}
+	return nil
+}
+
+func readInstruments() ([]engine.OrderBookConfig, error) {
+	tickSize := parseIntEnv("TICK_SIZE", 1)
+	maxDepth := int(parseIntEnv("MAX_DEPTH", 100))
+
+	path := os.Getenv("INSTRUMENTS_FILE")
+	if path == "" {
+		symbol := getEnv("SYMBOL", defaultSymbol)
+		return []engine.OrderBookConfig{{Symbol: symbol, TickSize: tickSize, MaxDepth: maxDepth}}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read instruments: %w", err)
+	}
+	var instruments []instrumentConfig
+	if err := json.Unmarshal(data, &instruments); err != nil {
+		return nil, fmt.Errorf("parse instruments %s: %w", path, err)
+	}
+
+	cfgs := make([]engine.OrderBookConfig, 0, len(instruments))
+	for _, inst := range instruments {
+		cfg := engine.OrderBookConfig{Symbol: inst.Symbol, TickSize: inst.TickSize, MaxDepth: inst.MaxDepth}
+		if cfg.TickSize == 0 {
+			cfg.TickSize = tickSize
+		}
+		if cfg.MaxDepth == 0 {
+			cfg.MaxDepth = maxDepth
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// applyJournal gives every book a journal under JOURNAL_DIR, named after its
+// symbol, so resting orders survive restarts. JOURNAL_SYNC picks the fsync
+// policy (always, interval or never) and JOURNAL_SYNC_INTERVAL the interval.
+// SNAPSHOT_EVERY sets how many journal records pass between book snapshots.
+func applyJournal(cfgs []engine.OrderBookConfig) error {
+	dir := os.Getenv("JOURNAL_DIR")
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create journal dir: %w", err)
+	}
+
+	var policy engine.SyncPolicy
+	switch sync := getEnv("JOURNAL_SYNC", "always"); sync {
+	case "always":
+		policy = engine.SyncAlways
+	case "interval":
+		policy = engine.SyncInterval
+	case "never":
+		policy = engine.SyncNever
+	default:
+		return fmt.Errorf("unknown JOURNAL_SYNC %q", sync)
+	}
+	var interval time.Duration
+	if value := os.Getenv("JOURNAL_SYNC_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("parse JOURNAL_SYNC_INTERVAL: %w", err)
+		}
+		interval = parsed
+	}
+
+	snapshotEvery := int(parseIntEnv("SNAPSHOT_EVERY", 10000))
+
+	for i := range cfgs {
+		cfgs[i].Journal = engine.JournalConfig{
+			Path:          filepath.Join(dir, cfgs[i].Symbol+".journal"),
+			Sync:          policy,
+			SyncInterval:  interval,
+			SnapshotEvery: snapshotEvery,
+		}
+	}
+	return nil
+}
@@ -22,43 +22,245 @@ const (
 )
 
 type server struct {
-	book       *engine.OrderBook
+	exchange   *engine.Exchange
+	accounts   *engine.Accounts    // nil unless ACCOUNTS_FILE is set
+	risk       *engine.RiskGateway // nil unless RISK_FILE is set
+	fees       *engine.FeeLedger   // fee totals by account and day
+	router     engine.OrderRouter  // order entry: risk, then accounts, then the exchange
+	orderIDs   *orderIDs           // IDs for orders submitted without one
+	submitted  *submissions        // answers to orders with client order IDs
 	tradeHub   *hub[engine.MatchResult]
 	bookHub    *hub[engine.BookView]
+	reportHub  *hub[engine.ExecutionReport]
+	depthHub   *hub[engine.DepthUpdate]
+	l3Hub      *hub[engine.L3Event]
 	upgrader   websocket.Upgrader
 	authToken  string
 	corsOrigin string
 }
 
 type orderRequest struct {
-	ID       string `json:"id"`
-	Symbol   string `json:"symbol"`
-	Side     string `json:"side"`
-	Type     string `json:"type"`
-	Price    int64  `json:"price"`
-	Quantity int64  `json:"quantity"`
+	ID          string     `json:"id"`
+	Symbol      string     `json:"symbol"`
+	Side        string     `json:"side"`
+	Type        string     `json:"type"`
+	Price       int64      `json:"price"`
+	StopPrice   int64      `json:"stopPrice"`
+	Quantity    int64      `json:"quantity"`
+	Display     int64      `json:"displayQuantity"`
+	TimeInForce string     `json:"timeInForce"`
+	ExpireAt    *time.Time `json:"expireAt"`
+	PostOnly    bool       `json:"postOnly"`
+	Slide       bool       `json:"postOnlySlide"`
+	Owner       string     `json:"owner"`
+	SelfTrade   string     `json:"selfTrade"`
+	// ClientOrderID makes a retried request idempotent: a repeat within the
+	// dedup window gets the first answer back.
+	ClientOrderID string `json:"clientOrderId"`
 }
 
 type orderResponse struct {
-	Status string `json:"status"`
+	Status        string `json:"status"`
+	ID            string `json:"id,omitempty"`
+	ClientOrderID string `json:"clientOrderId,omitempty"`
+}
+
+// maxBatchOrders caps the orders in one POST /orders/batch.
+const maxBatchOrders = 500
+
+type batchRequest struct {
+	Orders []orderRequest `json:"orders"`
+}
+
+type batchResponse struct {
+	Results []orderResult `json:"results"`
+}
+
+// orderResult answers one order request of a batch or a session.
+type orderResult struct {
+	Status        string `json:"status"` // accepted, canceled, amended or rejected
+	ID            string `json:"id,omitempty"`
+	ClientOrderID string `json:"clientOrderId,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Code          string `json:"code,omitempty"`
+}
+
+type canceledOrder struct {
+	ID     string `json:"id"`
+	Symbol string `json:"symbol"`
+}
+
+type massCancelResponse struct {
+	Status string          `json:"status"`
+	Orders []canceledOrder `json:"orders"`
+}
+
+type amendRequest struct {
+	Price    *int64 `json:"price"`
+	Quantity *int64 `json:"quantity"`
+}
+
+type replaceRequest struct {
+	NewID    string `json:"newId"`
+	Price    *int64 `json:"price"`
+	Quantity *int64 `json:"quantity"`
 }
 
 type snapshotResponse struct {
-	BestBid *publicOrder `json:"bestBid,omitempty"`
-	BestAsk *publicOrder `json:"bestAsk,omitempty"`
+	BestBid  *publicOrder  `json:"bestBid,omitempty"`
+	BestAsk  *publicOrder  `json:"bestAsk,omitempty"`
+	Sequence int64         `json:"sequence,omitempty"`
+	Bids     []publicLevel `json:"bids,omitempty"`
+	Asks     []publicLevel `json:"asks,omitempty"`
+}
+
+type publicLevel struct {
+	Price    int64 `json:"price"`
+	Quantity int64 `json:"quantity"`
+	Orders   int   `json:"orders"`
+}
+
+type depthMessage struct {
+	Symbol   string        `json:"symbol"`
+	Sequence int64         `json:"sequence"`
+	Bids     []publicLevel `json:"bids"`
+	Asks     []publicLevel `json:"asks"`
 }
 
 type publicOrder struct {
-	ID        string    `json:"id"`
+	ID          string     `json:"id"`
+	Symbol      string     `json:"symbol"`
+	Side        string     `json:"side"`
+	Type        string     `json:"type"`
+	Price       int64      `json:"price"`
+	StopPrice   int64      `json:"stopPrice,omitempty"`
+	Quantity    int64      `json:"quantity"`
+	Remaining   int64      `json:"remaining"`
+	Timestamp   time.Time  `json:"timestamp"`
+	TimeInForce string     `json:"timeInForce"`
+	ExpireAt    *time.Time `json:"expireAt,omitempty"`
+	PostOnly    bool       `json:"postOnly,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+}
+
+type publicOrderStatus struct {
+	ID            string    `json:"id"`
+	ClientOrderID string    `json:"clientOrderId,omitempty"`
+	Symbol        string    `json:"symbol"`
+	Side          string    `json:"side"`
+	Type          string    `json:"type"`
+	Owner         string    `json:"owner,omitempty"`
+	State         string    `json:"state"`
+	Price         int64     `json:"price"`
+	StopPrice     int64     `json:"stopPrice,omitempty"`
+	Quantity      int64     `json:"quantity"`
+	FilledQty     int64     `json:"filledQty"`
+	AvgPrice      float64   `json:"avgPrice"`
+	Remaining     int64     `json:"remaining"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type ordersResponse struct {
+	Orders []publicOrderStatus `json:"orders"`
+}
+
+type stopsResponse struct {
+	Stops []*publicOrder `json:"stops"`
+}
+
+type publicReport struct {
+	OrderID       string    `json:"orderId"`
+	ClientOrderID string    `json:"clientOrderId,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	Symbol        string    `json:"symbol"`
+	Side          string    `json:"side"`
+	Type          string    `json:"type"`
+	State         string    `json:"state"`
+	Price         int64     `json:"price"`
+	Quantity      int64     `json:"quantity"`
+	LastPrice     int64     `json:"lastPrice,omitempty"`
+	LastQty       int64     `json:"lastQty,omitempty"`
+	Fee           int64     `json:"fee,omitempty"`
+	Liquidity     string    `json:"liquidity,omitempty"`
+	CumQty        int64     `json:"cumQty"`
+	LeavesQty     int64     `json:"leavesQty"`
+	Reason        string    `json:"reason,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type publicL3Event struct {
+	Sequence  int64     `json:"sequence"`
+	Type      string    `json:"type"`
+	OrderID   string    `json:"orderId"`
 	Symbol    string    `json:"symbol"`
 	Side      string    `json:"side"`
-	Type      string    `json:"type"`
 	Price     int64     `json:"price"`
 	Quantity  int64     `json:"quantity"`
-	Remaining int64     `json:"remaining"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
+type l3SnapshotMessage struct {
+	Symbol   string         `json:"symbol"`
+	Sequence int64          `json:"sequence"`
+	Bids     []*publicOrder `json:"bids"`
+	Asks     []*publicOrder `json:"asks"`
+}
+
+type symbolsResponse struct {
+	Symbols []instrumentConfig `json:"symbols"`
+}
+
+type droppedCounts struct {
+	Trades  uint64 `json:"trades"`
+	Updates uint64 `json:"updates"`
+	Reports uint64 `json:"reports"`
+	Depth   uint64 `json:"depth"`
+	L3      uint64 `json:"l3"`
+}
+
+type statsResponse struct {
+	Dropped map[string]droppedCounts `json:"dropped"`
+}
+
+type publicBalance struct {
+	Account      string                   `json:"account"`
+	Cash         int64                    `json:"cash"`
+	ReservedCash int64                    `json:"reservedCash"`
+	Positions    map[string]publicHolding `json:"positions"`
+}
+
+type publicHolding struct {
+	Quantity int64 `json:"quantity"`
+	Reserved int64 `json:"reserved"`
+}
+
+type balanceMessage struct {
+	Balance   publicBalance `json:"balance"`
+	Reason    string        `json:"reason"`
+	OrderID   string        `json:"orderId,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+type feeTotal struct {
+	Account  string `json:"account"`
+	Day      string `json:"day"`
+	Fills    int    `json:"fills"`
+	Notional int64  `json:"notional"`
+	Paid     int64  `json:"paid"`
+	Rebated  int64  `json:"rebated"`
+	Net      int64  `json:"net"`
+}
+
+type feesResponse struct {
+	Fees []feeTotal `json:"fees"`
+}
+
+type killResponse struct {
+	Account  string `json:"account"`
+	Blocked  bool   `json:"blocked"`
+	Canceled int    `json:"canceled,omitempty"`
+}
+
 type outboundMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
@@ -66,42 +268,93 @@ type outboundMessage struct {
 
 func main() {
 	listenAddr := getEnv("LISTEN_ADDR", defaultListenAddr)
-	symbol := getEnv("SYMBOL", defaultSymbol)
-	tickSize := parseIntEnv("TICK_SIZE", 1)
-	maxDepth := int(parseIntEnv("MAX_DEPTH", 100))
 	authToken := os.Getenv("AUTH_TOKEN")
 	corsOrigin := getEnv("CORS_ORIGIN", "*")
 
-	book := engine.NewOrderBook(engine.OrderBookConfig{Symbol: symbol, TickSize: tickSize, MaxDepth: maxDepth})
-	srv := newServer(book, authToken, corsOrigin)
+	instruments, err := loadInstruments()
+	if err != nil {
+		log.Fatal(err)
+	}
+	exchange, err := engine.NewExchange(instruments)
+	if err != nil {
+		log.Fatal(err)
+	}
+	accounts, err := loadAccounts(exchange)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var next engine.OrderRouter = exchange
+	if accounts != nil {
+		next = accounts
+	}
+	risk, err := loadRisk(exchange, next)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := newServer(exchange, accounts, risk, authToken, corsOrigin)
 
-	log.Printf("listening on %s for symbol %s", listenAddr, symbol)
+	symbols := make([]string, 0, len(instruments))
+	for _, inst := range instruments {
+		symbols = append(symbols, inst.Symbol)
+	}
+	log.Printf("listening on %s for symbols %s", listenAddr, strings.Join(symbols, ","))
 	if err := http.ListenAndServe(listenAddr, srv.routes()); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func newServer(book *engine.OrderBook, authToken, corsOrigin string) *server {
+func newServer(exchange *engine.Exchange, accounts *engine.Accounts, risk *engine.RiskGateway, authToken, corsOrigin string) *server {
 	s := &server{
-		book:       book,
+		exchange:   exchange,
+		accounts:   accounts,
+		risk:       risk,
+		fees:       engine.NewFeeLedger(exchange),
+		router:     exchange,
+		orderIDs:   newOrderIDs(time.Now()),
+		submitted:  newSubmissions(),
 		tradeHub:   newHub[engine.MatchResult](),
 		bookHub:    newHub[engine.BookView](),
+		reportHub:  newHub[engine.ExecutionReport](),
+		depthHub:   newHub[engine.DepthUpdate](),
+		l3Hub:      newHub[engine.L3Event](),
 		upgrader:   websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
 		authToken:  authToken,
 		corsOrigin: corsOrigin,
 	}
 
+	if accounts != nil {
+		s.router = accounts
+	}
+	if risk != nil {
+		s.router = risk
+	}
+
 	go s.consumeTrades()
 	go s.consumeBookUpdates()
+	go s.consumeReports()
+	go s.consumeDepth()
+	go s.consumeL3()
 	return s
 }
 
 func (s *server) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/orders", s.withCORS(s.withAuth(http.HandlerFunc(s.handleOrder))))
+	mux.Handle("/orders/batch", s.withCORS(s.withAuth(http.HandlerFunc(s.handleBatch))))
+	mux.Handle("/orders/", s.withCORS(s.withAuth(http.HandlerFunc(s.handleOrderByID))))
 	mux.Handle("/book", s.withCORS(s.withAuth(http.HandlerFunc(s.handleSnapshot))))
+	mux.Handle("/symbols", s.withCORS(s.withAuth(http.HandlerFunc(s.handleSymbols))))
+	mux.Handle("/stops", s.withCORS(s.withAuth(http.HandlerFunc(s.handleStops))))
+	mux.Handle("/stats", s.withCORS(s.withAuth(http.HandlerFunc(s.handleStats))))
+	mux.Handle("/fees", s.withCORS(s.withAuth(http.HandlerFunc(s.handleFees))))
+	mux.Handle("/accounts/", s.withCORS(s.withAuth(http.HandlerFunc(s.handleAccount))))
 	mux.Handle("/ws/trades", s.withCORS(s.withAuth(http.HandlerFunc(s.handleTradeStream))))
 	mux.Handle("/ws/book", s.withCORS(s.withAuth(http.HandlerFunc(s.handleBookStream))))
+	mux.Handle("/ws/orders", s.withCORS(s.withAuth(http.HandlerFunc(s.handleOrderStream))))
+	mux.Handle("/ws/l3", s.withCORS(s.withAuth(http.HandlerFunc(s.handleL3Stream))))
+	// Sessions authenticate with their logon message instead.
+	mux.Handle("/ws/session", s.withCORS(http.HandlerFunc(s.handleSession)))
+	mux.Handle("/ws/balances", s.withCORS(s.withAuth(http.HandlerFunc(s.handleBalanceStream))))
 	return mux
 }
 
@@ -109,7 +362,7 @@ func (s *server) withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", s.corsOrigin)
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -139,6 +392,14 @@ func (s *server) withAuth(next http.Handler) http.Handler {
 }
 
 func (s *server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.handleListOrders(w, r)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		s.handleMassCancel(w, r)
+		return
+	}
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -155,13 +416,273 @@ func (s *server) handleOrder(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
+	if order.ID == "" {
+		order.ID = s.orderIDs.next()
+	}
+	status, payload := s.placeOrder(order)
+	writeJSON(w, status, payload)
+}
+
+// placeOrder submits a new order and returns the HTTP answer to it. A retry
+// of a client order ID waits for the first request to be answered and
+// repeats that answer.
+func (s *server) placeOrder(order engine.Order) (int, interface{}) {
+	if order.ClientOrderID == "" {
+		return s.submitOrder(order)
+	}
+	sub, first := s.submitted.begin(order.Owner, order.ClientOrderID, time.Now(), s.dedupWindow(order.Symbol))
+	if first {
+		status, payload := s.submitOrder(order)
+		s.submitted.finish(sub, status, payload)
+	}
+	<-sub.done
+	return sub.status, sub.payload
+}
+
+// dedupWindow is how long the book for symbol keeps finished order IDs, and
+// so how long answers to its orders are replayed. An unknown symbol has none:
+// the order is rejected and there is nothing worth replaying.
+func (s *server) dedupWindow(symbol string) time.Duration {
+	book, err := s.exchange.Book(symbol)
+	if err != nil {
+		return 0
+	}
+	return book.DedupWindow()
+}
+
+// submitOrder routes a new order and returns the HTTP answer to it.
+func (s *server) submitOrder(order engine.Order) (int, interface{}) {
+	return orderAnswer(order, s.router.SubmitOrder(order))
+}
+
+// orderAnswer is the HTTP answer to a new order the router accepted, or
+// rejected with err.
+func orderAnswer(order engine.Order, err error) (int, interface{}) {
+	if err != nil {
+		return orderErrorPayload(err)
+	}
+	return http.StatusAccepted, orderResponse{Status: "accepted", ID: order.ID, ClientOrderID: order.ClientOrderID}
+}
+
+// handleBatch submits several orders in one request, each book's share as
+// a single book request, and answers every order in batch order. An order
+// whose client order ID was already used gets the earlier answer, as on
+// POST /orders.
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+		return
+	}
+	if len(req.Orders) == 0 || len(req.Orders) > maxBatchOrders {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a batch needs 1 to %d orders", maxBatchOrders))
+		return
+	}
+
+	results := make([]orderResult, len(req.Orders))
+	var orders []engine.Order
+	var indexes []int
+	var subs []*submission
+	retries := make(map[int]*submission)
+	now := time.Now()
+	for i, item := range req.Orders {
+		order, err := buildOrder(item)
+		if err != nil {
+			results[i] = rejectedResult(err)
+			continue
+		}
+		if order.ID == "" {
+			order.ID = s.orderIDs.next()
+		}
+		var sub *submission
+		if order.ClientOrderID != "" {
+			var first bool
+			if sub, first = s.submitted.begin(order.Owner, order.ClientOrderID, now, s.dedupWindow(order.Symbol)); !first {
+				retries[i] = sub
+				continue
+			}
+		}
+		orders = append(orders, order)
+		indexes = append(indexes, i)
+		subs = append(subs, sub)
+	}
+
+	for j, err := range s.router.SubmitOrders(orders) {
+		status, payload := orderAnswer(orders[j], err)
+		if subs[j] != nil {
+			s.submitted.finish(subs[j], status, payload)
+		}
+		results[indexes[j]] = toOrderResult(payload)
+	}
+	// Retries are answered last: one may repeat an order of this very batch.
+	for i, sub := range retries {
+		<-sub.done
+		results[i] = toOrderResult(sub.payload)
+	}
+	writeJSON(w, http.StatusOK, batchResponse{Results: results})
+}
+
+// toOrderResult turns the answer orderAnswer gave into an order result.
+func toOrderResult(payload interface{}) orderResult {
+	switch p := payload.(type) {
+	case orderResponse:
+		return orderResult{Status: p.Status, ID: p.ID, ClientOrderID: p.ClientOrderID}
+	case map[string]string:
+		return orderResult{Status: "rejected", Error: p["error"], Code: p["code"]}
+	default:
+		return orderResult{Status: "rejected"}
+	}
+}
+
+func rejectedResult(err error) orderResult {
+	_, payload := orderErrorPayload(err)
+	return toOrderResult(payload)
+}
+
+// handleMassCancel cancels every working order matching ?owner=, ?symbol=
+// and ?side=. At least one is required, so a bare DELETE cannot empty the
+// exchange.
+func (s *server) handleMassCancel(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := engine.CancelFilter{Owner: query.Get("owner"), Symbol: query.Get("symbol")}
+	if value := query.Get("side"); value != "" {
+		side, err := parseSide(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Side = &side
+	}
+	if filter.Owner == "" && filter.Symbol == "" && filter.Side == nil {
+		writeError(w, http.StatusBadRequest, errors.New("owner, symbol or side is required"))
+		return
+	}
+
+	canceled, err := s.router.CancelOrders(filter)
+	if err != nil {
+		writeOrderError(w, err)
+		return
+	}
+	resp := massCancelResponse{Status: "canceled", Orders: make([]canceledOrder, 0, len(canceled))}
+	for _, order := range canceled {
+		resp.Orders = append(resp.Orders, canceledOrder{ID: order.ID, Symbol: order.Symbol})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleListOrders lists orders, optionally for one ?owner= and ?symbol=.
+// ?status= is open (the default), closed or all.
+func (s *server) handleListOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var scope engine.OrderScope
+	switch status := query.Get("status"); status {
+	case "", "open":
+		scope = engine.ScopeOpen
+	case "closed":
+		scope = engine.ScopeClosed
+	case "all":
+		scope = engine.ScopeAll
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown status %q", status))
+		return
+	}
+
+	var orders []engine.OrderStatus
+	var err error
+	if symbol := query.Get("symbol"); symbol != "" {
+		var book *engine.OrderBook
+		if book, err = s.exchange.Book(symbol); err == nil {
+			orders, err = book.Orders(query.Get("owner"), scope)
+		}
+	} else {
+		orders, err = s.exchange.Orders(query.Get("owner"), scope)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := ordersResponse{Orders: make([]publicOrderStatus, 0, len(orders))}
+	for _, status := range orders {
+		resp.Orders = append(resp.Orders, toPublicOrderStatus(status))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleOrderByID reports (GET), cancels (DELETE) or amends (PATCH)
+// /orders/{id}, and cancel-replaces it on POST /orders/{id}/replace. The
+// order's book is picked by ?symbol= like the other single-book endpoints.
+func (s *server) handleOrderByID(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/orders/")
+	id, replace := strings.CutSuffix(path, "/replace")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, errors.New("unknown order path"))
+		return
+	}
+	switch {
+	case replace && r.Method == http.MethodPost:
+	case !replace && (r.Method == http.MethodGet || r.Method == http.MethodDelete || r.Method == http.MethodPatch):
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
 
-	if err := s.book.SubmitOrder(order); err != nil {
+	book, err := s.bookFor(r)
+	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
+	symbol := book.Symbol()
 
-	writeJSON(w, http.StatusAccepted, orderResponse{Status: "accepted"})
+	switch {
+	case r.Method == http.MethodGet:
+		status, err := book.OrderStatus(id)
+		if err != nil {
+			writeOrderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, toPublicOrderStatus(status))
+	case replace:
+		var req replaceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+			return
+		}
+		if req.NewID == "" {
+			writeError(w, http.StatusBadRequest, errors.New("newId is required"))
+			return
+		}
+		if err := s.router.ReplaceOrder(symbol, id, req.NewID, req.Price, req.Quantity); err != nil {
+			writeOrderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, orderResponse{Status: "replaced"})
+	case r.Method == http.MethodPatch:
+		var req amendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %w", err))
+			return
+		}
+		if req.Price == nil && req.Quantity == nil {
+			writeError(w, http.StatusBadRequest, errors.New("price or quantity is required"))
+			return
+		}
+		if err := s.router.AmendOrder(symbol, id, req.Price, req.Quantity); err != nil {
+			writeOrderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, orderResponse{Status: "amended"})
+	default:
+		if err := s.router.CancelOrder(symbol, id); err != nil {
+			writeOrderError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, orderResponse{Status: "canceled"})
+	}
 }
 
 func (s *server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
@@ -170,16 +691,187 @@ func (s *server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := s.book.Snapshot()
+	book, err := s.bookFor(r)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, snapshotResponse{
+	var levels int
+	if raw := r.URL.Query().Get("depth"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid depth %q", raw))
+			return
+		}
+		levels = parsed
+	}
+
+	view, err := book.Snapshot()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := snapshotResponse{
 		BestBid: toPublicOrder(view.BestBid),
 		BestAsk: toPublicOrder(view.BestAsk),
-	})
+	}
+
+	if levels > 0 {
+		depth, err := book.Depth(levels)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Sequence = depth.Sequence
+		resp.Bids = toPublicLevels(depth.Bids)
+		resp.Asks = toPublicLevels(depth.Asks)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleStops(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	book, err := s.bookFor(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stops, err := book.StopOrders()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stopsResponse{Stops: toPublicOrders(stops)})
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := statsResponse{Dropped: make(map[string]droppedCounts)}
+	for symbol, stats := range s.exchange.OutputStats() {
+		resp.Dropped[symbol] = droppedCounts(stats)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	instruments := s.exchange.Instruments()
+	resp := symbolsResponse{Symbols: make([]instrumentConfig, 0, len(instruments))}
+	for _, inst := range instruments {
+		resp.Symbols = append(resp.Symbols, instrumentConfig{Symbol: inst.Symbol, TickSize: inst.TickSize, MaxDepth: inst.MaxDepth})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleFees sums the fees charged and rebates paid by account and UTC day,
+// optionally for one ?account= and between ?from= and ?to= days.
+func (s *server) handleFees(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid day %q, want YYYY-MM-DD", day))
+			return
+		}
+	}
+
+	totals := s.fees.Totals(query.Get("account"), from, to)
+	resp := feesResponse{Fees: make([]feeTotal, 0, len(totals))}
+	for _, total := range totals {
+		resp.Fees = append(resp.Fees, feeTotal(total))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/accounts/")
+	if id, ok := strings.CutSuffix(path, "/kill"); ok {
+		s.handleKill(w, r, id)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.accounts == nil {
+		writeError(w, http.StatusNotFound, errors.New("accounts are not enabled"))
+		return
+	}
+
+	id := path
+	balance, err := s.accounts.Balance(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPublicBalance(balance))
+}
+
+// handleKill turns the kill switch on (POST) or off (DELETE) for an account.
+func (s *server) handleKill(w http.ResponseWriter, r *http.Request, id string) {
+	if s.risk == nil {
+		writeError(w, http.StatusNotFound, errors.New("risk checks are not enabled"))
+		return
+	}
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errors.New("account id is required"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		canceled := s.risk.Block(id)
+		writeJSON(w, http.StatusOK, killResponse{Account: id, Blocked: true, Canceled: canceled})
+	case http.MethodDelete:
+		s.risk.Unblock(id)
+		writeJSON(w, http.StatusOK, killResponse{Account: id})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// bookFor resolves the ?symbol= query parameter, defaulting to the only
+// instrument when the exchange lists just one.
+func (s *server) bookFor(r *http.Request) (*engine.OrderBook, error) {
+	return s.bookNamed(r.URL.Query().Get("symbol"))
+}
+
+// bookNamed finds the book for symbol, which may be empty when only one
+// instrument is listed.
+func (s *server) bookNamed(symbol string) (*engine.OrderBook, error) {
+	if symbol == "" {
+		instruments := s.exchange.Instruments()
+		if len(instruments) != 1 {
+			return nil, errors.New("symbol is required")
+		}
+		symbol = instruments[0].Symbol
+	}
+	return s.exchange.Book(symbol)
 }
 
 func (s *server) handleTradeStream(w http.ResponseWriter, r *http.Request) {
@@ -189,10 +881,14 @@ func (s *server) handleTradeStream(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	symbol := r.URL.Query().Get("symbol")
 	sub := s.tradeHub.Subscribe(32)
 	defer s.tradeHub.Unsubscribe(sub)
 
 	for trade := range sub.ch {
+		if symbol != "" && trade.Symbol != symbol {
+			continue
+		}
 		msg := outboundMessage{Type: "trade", Data: toPublicMatch(trade)}
 		if err := conn.WriteJSON(msg); err != nil {
 			return
@@ -201,16 +897,35 @@ func (s *server) handleTradeStream(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *server) handleBookStream(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("mode") == "depth" {
+		book, err := s.bookFor(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.streamDepth(conn, book)
+		return
+	}
+
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
 
+	symbol := r.URL.Query().Get("symbol")
 	sub := s.bookHub.Subscribe(32)
 	defer s.bookHub.Unsubscribe(sub)
 
 	for view := range sub.ch {
+		if symbol != "" && view.Symbol != symbol {
+			continue
+		}
 		msg := outboundMessage{Type: "book", Data: snapshotResponse{
 			BestBid: toPublicOrder(view.BestBid),
 			BestAsk: toPublicOrder(view.BestAsk),
@@ -221,21 +936,200 @@ func (s *server) handleBookStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// streamDepth sends a full ladder snapshot followed by incremental level
+// updates. If an update is missed, it resynchronizes with a fresh snapshot.
+func (s *server) streamDepth(conn *websocket.Conn, book *engine.OrderBook) {
+	sub := s.depthHub.Subscribe(256)
+	defer s.depthHub.Unsubscribe(sub)
+
+	sendSnapshot := func() (int64, bool) {
+		depth, err := book.Depth(0)
+		if err != nil {
+			return 0, false
+		}
+		msg := outboundMessage{Type: "depthSnapshot", Data: depthMessage{
+			Symbol:   depth.Symbol,
+			Sequence: depth.Sequence,
+			Bids:     toPublicLevels(depth.Bids),
+			Asks:     toPublicLevels(depth.Asks),
+		}}
+		return depth.Sequence, conn.WriteJSON(msg) == nil
+	}
+
+	last, ok := sendSnapshot()
+	if !ok {
+		return
+	}
+	for update := range sub.ch {
+		if update.Symbol != book.Symbol() || update.Sequence <= last {
+			continue
+		}
+		if update.Sequence != last+1 {
+			if last, ok = sendSnapshot(); !ok {
+				return
+			}
+			continue
+		}
+		last = update.Sequence
+		msg := outboundMessage{Type: "depthUpdate", Data: depthMessage{
+			Symbol:   update.Symbol,
+			Sequence: update.Sequence,
+			Bids:     toPublicLevels(update.Bids),
+			Asks:     toPublicLevels(update.Asks),
+		}}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// handleL3Stream sends every resting order followed by market-by-order
+// events. If an event is missed, it resynchronizes with a fresh snapshot.
+func (s *server) handleL3Stream(w http.ResponseWriter, r *http.Request) {
+	book, err := s.bookFor(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := s.l3Hub.Subscribe(1024)
+	defer s.l3Hub.Unsubscribe(sub)
+
+	sendSnapshot := func() (int64, bool) {
+		snapshot, err := book.L3Snapshot()
+		if err != nil {
+			return 0, false
+		}
+		msg := outboundMessage{Type: "l3Snapshot", Data: l3SnapshotMessage{
+			Symbol:   snapshot.Symbol,
+			Sequence: snapshot.Sequence,
+			Bids:     toPublicOrders(snapshot.Bids),
+			Asks:     toPublicOrders(snapshot.Asks),
+		}}
+		return snapshot.Sequence, conn.WriteJSON(msg) == nil
+	}
+
+	last, ok := sendSnapshot()
+	if !ok {
+		return
+	}
+	for event := range sub.ch {
+		if event.Symbol != book.Symbol() || event.Sequence <= last {
+			continue
+		}
+		if event.Sequence != last+1 {
+			if last, ok = sendSnapshot(); !ok {
+				return
+			}
+			continue
+		}
+		last = event.Sequence
+		msg := outboundMessage{Type: "l3", Data: toPublicL3Event(event)}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) handleOrderStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	orderID := r.URL.Query().Get("orderId")
+	symbol := r.URL.Query().Get("symbol")
+	owner := r.URL.Query().Get("owner")
+	sub := s.reportHub.Subscribe(64)
+	defer s.reportHub.Unsubscribe(sub)
+
+	for report := range sub.ch {
+		if orderID != "" && report.OrderID != orderID {
+			continue
+		}
+		if symbol != "" && report.Symbol != symbol {
+			continue
+		}
+		if owner != "" && report.Owner != owner {
+			continue
+		}
+		msg := outboundMessage{Type: "execution", Data: toPublicReport(report)}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) handleBalanceStream(w http.ResponseWriter, r *http.Request) {
+	if s.accounts == nil {
+		writeError(w, http.StatusNotFound, errors.New("accounts are not enabled"))
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	account := r.URL.Query().Get("account")
+	sub := s.accounts.SubscribeBalances(engine.SubscribeOptions{Buffer: 64})
+	defer sub.Unsubscribe()
+
+	for update := range sub.C {
+		if account != "" && update.Balance.Account != account {
+			continue
+		}
+		msg := outboundMessage{Type: "balance", Data: balanceMessage{
+			Balance:   toPublicBalance(update.Balance),
+			Reason:    update.Reason,
+			OrderID:   update.OrderID,
+			Timestamp: update.Timestamp,
+		}}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
 func (s *server) consumeTrades() {
-	for trade := range s.book.Trades() {
+	for trade := range s.exchange.Trades() {
 		s.tradeHub.Broadcast(trade)
 	}
 }
 
 func (s *server) consumeBookUpdates() {
-	for view := range s.book.BookUpdates() {
+	for view := range s.exchange.BookUpdates() {
 		s.bookHub.Broadcast(view)
 	}
 }
 
+func (s *server) consumeReports() {
+	for report := range s.exchange.ExecutionReports() {
+		s.reportHub.Broadcast(report)
+	}
+}
+
+func (s *server) consumeDepth() {
+	for update := range s.exchange.DepthUpdates() {
+		s.depthHub.Broadcast(update)
+	}
+}
+
+func (s *server) consumeL3() {
+	for event := range s.exchange.L3Events() {
+		s.l3Hub.Broadcast(event)
+	}
+}
+
 func buildOrder(req orderRequest) (engine.Order, error) {
-	if req.ID == "" || req.Symbol == "" {
-		return engine.Order{}, errors.New("id and symbol are required")
+	if req.Symbol == "" {
+		return engine.Order{}, errors.New("symbol is required")
 	}
 	if req.Quantity <= 0 {
 		return engine.Order{}, errors.New("quantity must be positive")
@@ -249,15 +1143,44 @@ func buildOrder(req orderRequest) (engine.Order, error) {
 	if err != nil {
 		return engine.Order{}, err
 	}
+	tif, err := parseTimeInForce(req.TimeInForce)
+	if err != nil {
+		return engine.Order{}, err
+	}
+	selfTrade, err := parseSelfTrade(req.SelfTrade)
+	if err != nil {
+		return engine.Order{}, err
+	}
+	if selfTrade != engine.SelfTradeAllow && req.Owner == "" {
+		return engine.Order{}, errors.New("selfTrade requires owner")
+	}
 
-	return engine.Order{
-		ID:       req.ID,
-		Symbol:   req.Symbol,
-		Side:     side,
-		Type:     ordType,
-		Price:    req.Price,
-		Quantity: req.Quantity,
-	}, nil
+	order := engine.Order{
+		ID:              req.ID,
+		Symbol:          req.Symbol,
+		Side:            side,
+		Type:            ordType,
+		Price:           req.Price,
+		StopPrice:       req.StopPrice,
+		Quantity:        req.Quantity,
+		DisplayQuantity: req.Display,
+		TimeInForce:     tif,
+		Owner:           req.Owner,
+		SelfTrade:       selfTrade,
+		ClientOrderID:   req.ClientOrderID,
+	}
+	if req.ExpireAt != nil {
+		order.ExpireAt = *req.ExpireAt
+	}
+	switch {
+	case req.PostOnly && req.Slide:
+		order.PostOnly = engine.PostOnlySlide
+	case req.PostOnly:
+		order.PostOnly = engine.PostOnlyReject
+	case req.Slide:
+		return engine.Order{}, errors.New("postOnlySlide requires postOnly")
+	}
+	return order, nil
 }
 
 func parseSide(value string) (engine.Side, error) {
@@ -277,36 +1200,180 @@ func parseOrderType(value string) (engine.OrderType, error) {
 		return engine.Limit, nil
 	case "market", "mkt":
 		return engine.Market, nil
+	case "stop", "stp":
+		return engine.Stop, nil
+	case "stop_limit", "stop-limit", "stoplimit":
+		return engine.StopLimit, nil
 	default:
 		return 0, fmt.Errorf("unknown order type %s", value)
 	}
 }
 
+func parseTimeInForce(value string) (engine.TimeInForce, error) {
+	switch strings.ToLower(value) {
+	case "", "gtc":
+		return engine.GTC, nil
+	case "ioc":
+		return engine.IOC, nil
+	case "fok":
+		return engine.FOK, nil
+	case "day":
+		return engine.DAY, nil
+	case "gtd":
+		return engine.GTD, nil
+	default:
+		return 0, fmt.Errorf("unknown time in force %s", value)
+	}
+}
+
+func parseSelfTrade(value string) (engine.SelfTradeMode, error) {
+	switch strings.ToLower(value) {
+	case "", "allow":
+		return engine.SelfTradeAllow, nil
+	case "cancel_newest", "cancel-newest":
+		return engine.SelfTradeCancelNewest, nil
+	case "cancel_oldest", "cancel-oldest":
+		return engine.SelfTradeCancelOldest, nil
+	case "cancel_both", "cancel-both":
+		return engine.SelfTradeCancelBoth, nil
+	case "decrement", "decrement_and_cancel", "decrement-and-cancel":
+		return engine.SelfTradeDecrement, nil
+	default:
+		return 0, fmt.Errorf("unknown self-trade mode %s", value)
+	}
+}
+
 func toPublicOrder(order *engine.Order) *publicOrder {
 	if order == nil {
 		return nil
 	}
-	return &publicOrder{
-		ID:        order.ID,
-		Symbol:    order.Symbol,
-		Side:      sideString(order.Side),
-		Type:      typeString(order.Type),
-		Price:     order.Price,
-		Quantity:  order.Quantity,
-		Remaining: order.Remaining,
-		Timestamp: order.Timestamp,
+	public := &publicOrder{
+		ID:          order.ID,
+		Symbol:      order.Symbol,
+		Side:        sideString(order.Side),
+		Type:        typeString(order.Type),
+		Price:       order.Price,
+		StopPrice:   order.StopPrice,
+		Quantity:    order.Quantity,
+		Remaining:   order.Remaining,
+		Timestamp:   order.Timestamp,
+		TimeInForce: tifString(order.TimeInForce),
+		PostOnly:    order.PostOnly != engine.PostOnlyOff,
+		Owner:       order.Owner,
+	}
+	if !order.ExpireAt.IsZero() {
+		expireAt := order.ExpireAt
+		public.ExpireAt = &expireAt
+	}
+	return public
+}
+
+func toPublicOrderStatus(status engine.OrderStatus) publicOrderStatus {
+	order := status.Order
+	return publicOrderStatus{
+		ID:            order.ID,
+		ClientOrderID: order.ClientOrderID,
+		Symbol:        order.Symbol,
+		Side:          sideString(order.Side),
+		Type:          typeString(order.Type),
+		Owner:         order.Owner,
+		State:         stateString(status.State),
+		Price:         order.Price,
+		StopPrice:     order.StopPrice,
+		Quantity:      order.Quantity,
+		FilledQty:     status.CumQty,
+		AvgPrice:      status.AvgPrice,
+		Remaining:     status.LeavesQty,
+		Timestamp:     order.Timestamp,
 	}
 }
 
 func toPublicMatch(match engine.MatchResult) map[string]interface{} {
 	return map[string]interface{}{
-		"symbol":      match.Symbol,
-		"buyOrderId":  match.BuyOrderID,
-		"sellOrderId": match.SellOrderID,
-		"price":       match.Price,
-		"quantity":    match.Quantity,
-		"executedAt":  match.Timestamp,
+		"tradeId":       match.TradeID,
+		"symbol":        match.Symbol,
+		"buyOrderId":    match.BuyOrderID,
+		"sellOrderId":   match.SellOrderID,
+		"makerOrderId":  match.MakerOrderID,
+		"takerOrderId":  match.TakerOrderID,
+		"aggressorSide": sideString(match.AggressorSide),
+		"price":         match.Price,
+		"quantity":      match.Quantity,
+		"buyFee":        match.BuyFee,
+		"sellFee":       match.SellFee,
+		"sequence":      match.Sequence,
+		"executedAt":    match.Timestamp,
+	}
+}
+
+func toPublicOrders(orders []engine.Order) []*publicOrder {
+	out := make([]*publicOrder, 0, len(orders))
+	for i := range orders {
+		out = append(out, toPublicOrder(&orders[i]))
+	}
+	return out
+}
+
+func toPublicL3Event(event engine.L3Event) publicL3Event {
+	return publicL3Event{
+		Sequence:  event.Sequence,
+		Type:      l3TypeString(event.Type),
+		OrderID:   event.OrderID,
+		Symbol:    event.Symbol,
+		Side:      sideString(event.Side),
+		Price:     event.Price,
+		Quantity:  event.Quantity,
+		Timestamp: event.Timestamp,
+	}
+}
+
+func toPublicLevels(levels []engine.PriceLevel) []publicLevel {
+	out := make([]publicLevel, 0, len(levels))
+	for _, level := range levels {
+		out = append(out, publicLevel{Price: level.Price, Quantity: level.Quantity, Orders: level.Orders})
+	}
+	return out
+}
+
+func toPublicBalance(balance engine.Balance) publicBalance {
+	public := publicBalance{
+		Account:      balance.Account,
+		Cash:         balance.Cash,
+		ReservedCash: balance.ReservedCash,
+		Positions:    make(map[string]publicHolding, len(balance.Positions)),
+	}
+	for symbol, h := range balance.Positions {
+		public.Positions[symbol] = publicHolding{Quantity: h.Quantity, Reserved: h.Reserved}
 	}
+	return public
+}
+
+func toPublicReport(report engine.ExecutionReport) publicReport {
+	public := publicReport{
+		OrderID:       report.OrderID,
+		ClientOrderID: report.ClientOrderID,
+		Owner:         report.Owner,
+		Symbol:        report.Symbol,
+		Side:          sideString(report.Side),
+		Type:          typeString(report.Type),
+		State:         stateString(report.State),
+		Price:         report.Price,
+		Quantity:      report.Quantity,
+		LastPrice:     report.LastPrice,
+		LastQty:       report.LastQty,
+		CumQty:        report.CumQty,
+		LeavesQty:     report.LeavesQty,
+		Fee:           report.Fee,
+		Reason:        report.Reason,
+		Timestamp:     report.Timestamp,
+	}
+	if report.LastQty > 0 {
+		public.Liquidity = "taker"
+		if report.Maker {
+			public.Liquidity = "maker"
+		}
+	}
+	return public
 }
 
 func sideString(side engine.Side) string {
@@ -317,16 +1384,101 @@ func sideString(side engine.Side) string {
 }
 
 func typeString(t engine.OrderType) string {
-	if t == engine.Limit {
+	switch t {
+	case engine.Limit:
 		return "limit"
+	case engine.Stop:
+		return "stop"
+	case engine.StopLimit:
+		return "stop_limit"
+	default:
+		return "market"
+	}
+}
+
+func tifString(tif engine.TimeInForce) string {
+	switch tif {
+	case engine.IOC:
+		return "ioc"
+	case engine.FOK:
+		return "fok"
+	case engine.DAY:
+		return "day"
+	case engine.GTD:
+		return "gtd"
+	default:
+		return "gtc"
+	}
+}
+
+func stateString(state engine.OrderState) string {
+	switch state {
+	case engine.StateNew:
+		return "new"
+	case engine.StatePartiallyFilled:
+		return "partially_filled"
+	case engine.StateFilled:
+		return "filled"
+	case engine.StateCanceled:
+		return "canceled"
+	case engine.StateRejected:
+		return "rejected"
+	case engine.StateReplaced:
+		return "replaced"
+	default:
+		return "expired"
+	}
+}
+
+func l3TypeString(t engine.L3EventType) string {
+	switch t {
+	case engine.L3Add:
+		return "add"
+	case engine.L3Modify:
+		return "modify"
+	case engine.L3Delete:
+		return "delete"
+	default:
+		return "execute"
 	}
-	return "market"
 }
 
 func writeError(w http.ResponseWriter, code int, err error) {
 	writeJSON(w, code, map[string]string{"error": err.Error()})
 }
 
+// writeOrderError reports a rejected order request.
+func writeOrderError(w http.ResponseWriter, err error) {
+	status, payload := orderErrorPayload(err)
+	writeJSON(w, status, payload)
+}
+
+// orderErrorPayload answers a rejected order request, adding the reason code
+// of a risk or book rejection. Rate limiting answers 429 so clients know to
+// back off; unknown orders answer 404 and orders in the wrong state 409.
+func orderErrorPayload(err error) (int, map[string]string) {
+	var riskErr *engine.RiskError
+	if errors.As(err, &riskErr) {
+		status := http.StatusBadRequest
+		if riskErr.Code == engine.RiskRateLimit {
+			status = http.StatusTooManyRequests
+		}
+		return status, map[string]string{"error": riskErr.Reason, "code": riskErr.Code}
+	}
+	var orderErr *engine.OrderError
+	if errors.As(err, &orderErr) {
+		status := http.StatusBadRequest
+		switch orderErr.Code {
+		case engine.OrderNotFound:
+			status = http.StatusNotFound
+		case engine.OrderFilled, engine.OrderClosed, engine.OrderPendingStop, engine.OrderDuplicateID:
+			status = http.StatusConflict
+		}
+		return status, map[string]string{"error": orderErr.Reason, "code": orderErr.Code}
+	}
+	return http.StatusBadRequest, map[string]string{"error": err.Error()}
+}
+
 func writeJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
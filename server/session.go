@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"limitless/engine"
+)
+
+const (
+	// sessionLogonTimeout is how long a new session has to send its logon.
+	sessionLogonTimeout = 10 * time.Second
+	// sessionWriteTimeout is how long a write to a session may take before
+	// the session is dropped.
+	sessionWriteTimeout = 10 * time.Second
+	// sessionReportBuffer is how many execution reports per book a session
+	// may fall behind by before it is dropped.
+	sessionReportBuffer = 1024
+)
+
+// sessionRequest is a message from a session client. Fields not used by its
+// Type are ignored.
+type sessionRequest struct {
+	Type      string `json:"type"` // logon, new, cancel or amend
+	RequestID string `json:"requestId"`
+
+	// logon
+	Token   string `json:"token"`
+	Account string `json:"account"`
+
+	// new
+	Order *orderRequest `json:"order"`
+
+	// cancel and amend
+	Symbol   string `json:"symbol"`
+	ID       string `json:"id"`
+	Price    *int64 `json:"price"`
+	Quantity *int64 `json:"quantity"`
+}
+
+// sessionReply acks or rejects one request, echoing its ID.
+type sessionReply struct {
+	RequestID string `json:"requestId"`
+	orderResult
+}
+
+type sessionLogon struct {
+	Account string `json:"account"`
+}
+
+// handleSession runs an order entry session over one WebSocket. The client
+// logs on once, naming its account and the AUTH_TOKEN if one is set, then
+// sends new, cancel and amend requests. Each gets an ack or reject carrying
+// its request ID, and execution reports for the account's orders, from any
+// connection, arrive on the same socket.
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Replies and reports are written from different goroutines.
+	var writeMu sync.Mutex
+	send := func(typ string, data interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		return conn.WriteJSON(outboundMessage{Type: typ, Data: data})
+	}
+
+	logon, err := s.sessionLogon(conn)
+	if err != nil {
+		_ = send("reject", sessionReply{RequestID: logon.RequestID, orderResult: rejectedResult(err)})
+		return
+	}
+	account := logon.Account
+
+	// Subscribe before acknowledging the logon so no report of the
+	// session's orders is missed. A session never silently misses one
+	// either: once it falls too far behind to take the next, it is dropped.
+	for _, inst := range s.exchange.Instruments() {
+		book, _ := s.exchange.Book(inst.Symbol)
+		sub := book.SubscribeExecutionReports(engine.SubscribeOptions{Buffer: sessionReportBuffer, Policy: engine.OutputDrop, Owner: account})
+		defer sub.Unsubscribe()
+		go func() {
+			for report := range sub.C {
+				if sub.Dropped() > 0 || send("execution", toPublicReport(report)) != nil {
+					conn.Close()
+					return
+				}
+			}
+		}()
+	}
+	if err := send("logon", sessionLogon{Account: account}); err != nil {
+		return
+	}
+
+	for {
+		var req sessionRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		reply := sessionReply{RequestID: req.RequestID, orderResult: s.sessionRequest(account, req)}
+		typ := "ack"
+		if reply.Status == "rejected" {
+			typ = "reject"
+		}
+		if err := send(typ, reply); err != nil {
+			return
+		}
+	}
+}
+
+// sessionLogon reads the logon that must open a session.
+func (s *server) sessionLogon(conn *websocket.Conn) (sessionRequest, error) {
+	var req sessionRequest
+	_ = conn.SetReadDeadline(time.Now().Add(sessionLogonTimeout))
+	if err := conn.ReadJSON(&req); err != nil {
+		return req, fmt.Errorf("read logon: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if req.Type != "logon" {
+		return req, errors.New("the first message must be a logon")
+	}
+	if s.authToken != "" && req.Token != s.authToken {
+		return req, errors.New("missing or invalid token")
+	}
+	if req.Account == "" {
+		return req, errors.New("account is required")
+	}
+	return req, nil
+}
+
+// sessionRequest carries out one request of a session logged on as account.
+// New orders are entered under the account, and only its own orders can be
+// canceled or amended; others' answer not_found.
+func (s *server) sessionRequest(account string, req sessionRequest) orderResult {
+	switch req.Type {
+	case "new":
+		if req.Order == nil {
+			return rejectedResult(errors.New("order is required"))
+		}
+		if req.Order.Owner != "" && req.Order.Owner != account {
+			return rejectedResult(fmt.Errorf("order owner must be %s", account))
+		}
+		req.Order.Owner = account
+		order, err := buildOrder(*req.Order)
+		if err != nil {
+			return rejectedResult(err)
+		}
+		if order.ID == "" {
+			order.ID = s.orderIDs.next()
+		}
+		_, payload := s.placeOrder(order)
+		return toOrderResult(payload)
+
+	case "cancel", "amend":
+		book, err := s.bookNamed(req.Symbol)
+		if err != nil {
+			return rejectedResult(err)
+		}
+		status, err := book.OrderStatus(req.ID)
+		if err != nil {
+			return rejectedResult(err)
+		}
+		if status.Order.Owner != account {
+			return rejectedResult(&engine.OrderError{Code: engine.OrderNotFound, Reason: fmt.Sprintf("order %s not found", req.ID)})
+		}
+		if req.Type == "cancel" {
+			if err := s.router.CancelOrder(book.Symbol(), req.ID); err != nil {
+				return rejectedResult(err)
+			}
+			return orderResult{Status: "canceled", ID: req.ID, ClientOrderID: status.Order.ClientOrderID}
+		}
+		if err := s.router.AmendOrder(book.Symbol(), req.ID, req.Price, req.Quantity); err != nil {
+			return rejectedResult(err)
+		}
+		return orderResult{Status: "amended", ID: req.ID, ClientOrderID: status.Order.ClientOrderID}
+
+	default:
+		return rejectedResult(fmt.Errorf("unknown message type %q", req.Type))
+	}
+}
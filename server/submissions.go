@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// orderIDs assigns exchange order IDs. The prefix is the start time, so IDs
+// from an earlier run recovered from the journal are never handed out again.
+type orderIDs struct {
+	prefix string
+	seq    atomic.Int64
+}
+
+func newOrderIDs(start time.Time) *orderIDs {
+	return &orderIDs{prefix: strconv.FormatInt(start.UnixNano(), 36)}
+}
+
+func (g *orderIDs) next() string {
+	return g.prefix + "-" + strconv.FormatInt(g.seq.Add(1), 10)
+}
+
+// submissions remembers the answer to every order accepted with a client
+// order ID for the dedup window of its book, so a client retrying after a
+// lost response gets the original answer rather than a second order. Like
+// the book, which frees a rejected order's ID at once, it forgets a
+// rejection as soon as the requests waiting on it have their answer.
+type submissions struct {
+	mu    sync.Mutex
+	byKey map[string]*submission
+	queue []*submission // oldest first, for expiry
+}
+
+type submission struct {
+	key     string
+	expires time.Time
+	done    chan struct{} // closed once status and payload are set
+	status  int
+	payload interface{}
+}
+
+func newSubmissions() *submissions {
+	return &submissions{byKey: make(map[string]*submission)}
+}
+
+// begin claims the client order ID of owner for window. If it was already
+// used within the window it was claimed for, it returns that submission and
+// false; wait on its done channel before reading the answer. Otherwise the
+// caller must finish the new one.
+func (s *submissions) begin(owner, clientOrderID string, now time.Time, window time.Duration) (*submission, bool) {
+	key := owner + "\x00" + clientOrderID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Books can have different windows, so an expired submission may sit
+	// behind a live one in the queue; lookups check expiry themselves.
+	for len(s.queue) > 0 && !now.Before(s.queue[0].expires) {
+		if expired := s.queue[0]; s.byKey[expired.key] == expired {
+			delete(s.byKey, expired.key)
+		}
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+	}
+	if sub, ok := s.byKey[key]; ok && now.Before(sub.expires) {
+		return sub, false
+	}
+	sub := &submission{key: key, expires: now.Add(window), done: make(chan struct{})}
+	if window > 0 {
+		s.byKey[key] = sub
+		s.queue = append(s.queue, sub)
+	}
+	return sub, true
+}
+
+// finish records the answer to a submission and releases any retries
+// waiting on it. A rejected submission is forgotten, so the next request
+// with its client order ID is submitted again.
+func (s *submissions) finish(sub *submission, status int, payload interface{}) {
+	sub.status, sub.payload = status, payload
+	if status != http.StatusAccepted {
+		s.mu.Lock()
+		if s.byKey[sub.key] == sub {
+			delete(s.byKey, sub.key)
+		}
+		s.mu.Unlock()
+	}
+	close(sub.done)
+}
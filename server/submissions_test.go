@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSubmissionsReplayAcceptedAnswersForTheWindow(t *testing.T) {
+	subs := newSubmissions()
+	now := time.Unix(0, 0)
+
+	sub, first := subs.begin("alice", "c1", now, time.Minute)
+	if !first {
+		t.Fatalf("the first use of a client order ID should be submitted")
+	}
+	retry, first := subs.begin("alice", "c1", now.Add(time.Second), time.Minute)
+	if first || retry != sub {
+		t.Fatalf("a retry while the first is in flight should wait on it")
+	}
+	subs.finish(sub, http.StatusAccepted, "ok")
+	<-retry.done
+	if retry.status != http.StatusAccepted || retry.payload != "ok" {
+		t.Fatalf("expected the first answer, got %d %v", retry.status, retry.payload)
+	}
+
+	if _, first := subs.begin("bob", "c1", now, time.Minute); !first {
+		t.Fatalf("client order IDs are scoped to their owner")
+	}
+	if again, first := subs.begin("alice", "c1", now.Add(59*time.Second), time.Minute); first || again != sub {
+		t.Fatalf("an accepted answer should be replayed within the window")
+	}
+	if _, first := subs.begin("alice", "c1", now.Add(time.Minute), time.Minute); !first {
+		t.Fatalf("the client order ID should be free once the window has passed")
+	}
+}
+
+func TestSubmissionsForgetRejections(t *testing.T) {
+	subs := newSubmissions()
+	now := time.Unix(0, 0)
+
+	sub, _ := subs.begin("alice", "c1", now, time.Minute)
+	waiting, first := subs.begin("alice", "c1", now, time.Minute)
+	if first {
+		t.Fatalf("a retry while the first is in flight should wait on it")
+	}
+	subs.finish(sub, http.StatusBadRequest, "rejected")
+	<-waiting.done
+	if waiting.status != http.StatusBadRequest {
+		t.Fatalf("a retry already waiting should still get the rejection, got %d", waiting.status)
+	}
+
+	next, first := subs.begin("alice", "c1", now.Add(time.Second), time.Minute)
+	if !first || next == sub {
+		t.Fatalf("a rejected client order ID should be submitted again, as the book frees its order ID")
+	}
+	subs.finish(next, http.StatusAccepted, "ok")
+	if again, first := subs.begin("alice", "c1", now.Add(2*time.Second), time.Minute); first || again != next {
+		t.Fatalf("the accepted retry should now be remembered")
+	}
+}
+
+func TestSubmissionsWithoutWindowAreNotRemembered(t *testing.T) {
+	subs := newSubmissions()
+	now := time.Unix(0, 0)
+
+	sub, _ := subs.begin("alice", "c1", now, 0)
+	subs.finish(sub, http.StatusAccepted, "ok")
+	if _, first := subs.begin("alice", "c1", now, 0); !first {
+		t.Fatalf("a book without a dedup window should not replay answers")
+	}
+}
@@ -24,11 +24,15 @@ const getWsUrl = (path: string, token?: string) => {
 }
 
 type Trade = {
+  tradeId: string
   symbol: string
   price: number
   quantity: number
   buyOrderId: string
   sellOrderId: string
+  makerOrderId: string
+  takerOrderId: string
+  aggressorSide: 'buy' | 'sell'
   executedAt: string
 }
 
@@ -40,6 +44,9 @@ const normalizeTrade = (payload: any): Trade => ({
   quantity: Number(payload?.quantity ?? 0),
   buyOrderId: payload?.buyOrderId ?? payload?.buyOrderID ?? '',
   sellOrderId: payload?.sellOrderId ?? payload?.sellOrderID ?? '',
+  makerOrderId: payload?.makerOrderId ?? '',
+  takerOrderId: payload?.takerOrderId ?? '',
+  aggressorSide: payload?.aggressorSide === 'sell' ? 'sell' : 'buy',
   executedAt: payload?.executedAt ?? payload?.timestamp ?? new Date().toISOString(),
 })
 
@@ -59,9 +66,8 @@ function App() {
 
   const filteredTrades = useMemo(() => {
     return trades.filter((trade) => {
-      const lower = `${trade.buyOrderId} ${trade.sellOrderId}`.toLowerCase()
-      const containsMaker = lower.includes('maker')
-      const containsTaker = lower.includes('taker')
+      const containsMaker = trade.makerOrderId.toLowerCase().includes('maker')
+      const containsTaker = trade.takerOrderId.toLowerCase().includes('taker')
 
       if (containsMaker && !botVisibility.maker) return false
       if (containsTaker && !botVisibility.taker) return false
@@ -277,9 +283,9 @@ function App() {
           <div ref={tradeTapeRef} className="tape" aria-label="Trade tape">
             {filteredTrades.length === 0 && <div className="empty">Waiting for trades…</div>}
             {filteredTrades.map((trade, idx) => {
-              const isBidAggressor = idx % 2 === 0
+              const isBidAggressor = trade.aggressorSide === 'buy'
               return (
-                <div key={`${trade.buyOrderId}-${trade.sellOrderId}-${idx}`} className={`tape__row ${isBidAggressor ? 'tape__row--bid' : 'tape__row--ask'}`}>
+                <div key={trade.tradeId || `${trade.buyOrderId}-${trade.sellOrderId}-${idx}`} className={`tape__row ${isBidAggressor ? 'tape__row--bid' : 'tape__row--ask'}`}>
                   <div className="tape__cell tape__time">
                     {new Date(trade.executedAt).toLocaleTimeString([], { hour12: false })}
                   </div>
@@ -305,7 +311,7 @@ function App() {
             <div className="toggle-row">
               <div>
                 <p className="toggle__title">Maker bot</p>
-                <p className="muted">Hide trades tagged with maker order IDs.</p>
+                <p className="muted">Hide trades whose resting order is tagged maker.</p>
               </div>
               <label className="switch">
                 <input
@@ -319,7 +325,7 @@ function App() {
             <div className="toggle-row">
               <div>
                 <p className="toggle__title">Taker bot</p>
-                <p className="muted">Toggle trades marked with taker IDs.</p>
+                <p className="muted">Hide trades whose incoming order is tagged taker.</p>
               </div>
               <label className="switch">
                 <input